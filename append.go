@@ -0,0 +1,473 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/klauspost/compress/zip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/patrickbr/gtfsparser"
+	"io"
+	"io/fs"
+	"os"
+	opath "path"
+	"sort"
+	"strings"
+)
+
+// the files touched by Append, in the order they are written
+var appendFiles = []string{"shapes.txt", "calendar.txt", "calendar_dates.txt", "trips.txt", "stop_times.txt", "frequencies.txt"}
+
+// a csvTable is a parsed CSV file
+type csvTable struct {
+	header []string
+	rows   [][]string
+}
+
+func readCsvTable(r io.Reader) (*csvTable, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	recs, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(recs) == 0 {
+		return &csvTable{}, nil
+	}
+
+	header := recs[0]
+	if len(header) > 0 {
+		header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	}
+	for i := range header {
+		header[i] = strings.TrimSpace(header[i])
+	}
+
+	return &csvTable{header, recs[1:]}, nil
+}
+
+// col returns the index of a column, or -1
+func (t *csvTable) col(name string) int {
+	for i, h := range t.header {
+		if h == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// values returns the set of values of a column
+func (t *csvTable) values(name string) map[string]bool {
+	ret := make(map[string]bool)
+	i := t.col(name)
+	if i < 0 {
+		return ret
+	}
+	for _, row := range t.rows {
+		if i < len(row) {
+			ret[row[i]] = true
+		}
+	}
+	return ret
+}
+
+// filter removes all rows whose value in a column is in set
+func (t *csvTable) filter(name string, set map[string]bool) {
+	i := t.col(name)
+	if i < 0 {
+		return
+	}
+	rows := t.rows[:0]
+	for _, row := range t.rows {
+		if !set[row[i]] {
+			rows = append(rows, row)
+		}
+	}
+	t.rows = rows
+}
+
+// align adds the columns of n missing in t to t, and returns the rows
+// of n in the column order of t. It returns whether columns were added.
+func (t *csvTable) align(n *csvTable) ([][]string, bool) {
+	added := false
+	for _, h := range n.header {
+		if t.col(h) < 0 {
+			t.header = append(t.header, h)
+			added = true
+		}
+	}
+
+	if added {
+		for i := range t.rows {
+			for len(t.rows[i]) < len(t.header) {
+				t.rows[i] = append(t.rows[i], "")
+			}
+		}
+	}
+
+	rows := make([][]string, len(n.rows))
+	for i, nrow := range n.rows {
+		row := make([]string, len(t.header))
+		for j, h := range n.header {
+			row[t.col(h)] = nrow[j]
+		}
+		rows[i] = row
+	}
+
+	return rows, added
+}
+
+// Append adds the trips of feed, together with their stop times,
+// frequencies and shapes, and all services and shapes not yet defined,
+// to the existing feed at path, which may be a directory or a ZIP
+// file. Only shapes.txt, calendar.txt, calendar_dates.txt, trips.txt,
+// stop_times.txt and frequencies.txt are touched; they are rewritten
+// with the existing rows followed by the new ones, adding the columns
+// the existing files do not have.
+//
+// Trips whose ID is already used are rejected. Routes and stops referenced
+// by the new trips, but missing in the existing feed, are reported as
+// warnings. The existing feed is read and written through the output
+// backend, which must be able to read files. ZIP files are rewritten,
+// copying untouched files without recompressing them and keeping the
+// archive comment unless ZipComment is set.
+func (writer *Writer) Append(feed *gtfsparser.Feed, path string) error {
+	fileInfo, err := writer.backend().Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if fileInfo.IsDir() && writer.GzipFiles {
+		return writeError{path, "cannot append to gzip compressed files"}
+	}
+
+	var archive *zip.Reader
+	if !fileInfo.IsDir() {
+		if archive, err = writer.readZip(path); err != nil {
+			return err
+		}
+	}
+
+	existing, err := writer.readExistingTables(path, archive, append([]string{"routes.txt", "stops.txt"}, appendFiles...))
+	if err != nil {
+		return err
+	}
+
+	feed, attributions, err := writer.prepare(feed)
+	if err != nil {
+		return err
+	}
+
+	// the new rows, as written by the writer
+	fresh := make(map[string]*csvTable)
+	for _, name := range appendFiles {
+		var buf bytes.Buffer
+		if e := writer.writeTable(feed, attributions, name, &buf); e != nil {
+			return e
+		}
+		t, e := readCsvTable(&buf)
+		if e != nil {
+			return writeError{name, e.Error()}
+		}
+		fresh[name] = t
+	}
+
+	if err := writer.checkAppend(existing, fresh); err != nil {
+		return err
+	}
+
+	if archive == nil {
+		return writer.appendToDir(path, existing, fresh)
+	}
+
+	return writer.appendToZip(path, archive, existing, fresh)
+}
+
+// checkAppend drops services and shapes which are already defined
+// from the new rows, and checks the new trips against the existing feed
+func (writer *Writer) checkAppend(existing map[string]*csvTable, fresh map[string]*csvTable) error {
+	services := make(map[string]bool)
+	for _, name := range []string{"calendar.txt", "calendar_dates.txt"} {
+		if t := existing[name]; t != nil {
+			for id := range t.values("service_id") {
+				services[id] = true
+			}
+		}
+	}
+	fresh["calendar.txt"].filter("service_id", services)
+	fresh["calendar_dates.txt"].filter("service_id", services)
+
+	if t := existing["shapes.txt"]; t != nil {
+		fresh["shapes.txt"].filter("shape_id", t.values("shape_id"))
+	}
+
+	if t := existing["trips.txt"]; t != nil {
+		ids := t.values("trip_id")
+		dups := make([]string, 0)
+		for id := range fresh["trips.txt"].values("trip_id") {
+			if ids[id] {
+				dups = append(dups, id)
+			}
+		}
+		if len(dups) > 0 {
+			sort.Strings(dups)
+			return writeError{"trips.txt", "trip IDs already used: " + strings.Join(dups, ", ")}
+		}
+	}
+
+	refs := []struct{ file, col, target string }{
+		{"trips.txt", "route_id", "routes.txt"},
+		{"stop_times.txt", "stop_id", "stops.txt"},
+	}
+
+	for _, ref := range refs {
+		known := make(map[string]bool)
+		if t := existing[ref.target]; t != nil {
+			known = t.values(ref.col)
+		}
+		missing := make([]string, 0)
+		for id := range fresh[ref.file].values(ref.col) {
+			if !known[id] {
+				missing = append(missing, id)
+			}
+		}
+		sort.Strings(missing)
+		for _, id := range missing {
+			writer.warn(ref.file, id, ref.col, fmt.Sprintf("not defined in %s of the existing feed", ref.target))
+		}
+	}
+
+	return nil
+}
+
+// openExisting opens a file of the existing feed through the backend
+func (writer *Writer) openExisting(name string) (io.ReadCloser, error) {
+	open, ok := writer.backend().(openBackend)
+	if !ok {
+		return nil, writeError{name, "the output backend cannot read files"}
+	}
+
+	return open.Open(name)
+}
+
+// readZip reads the existing ZIP file at path
+func (writer *Writer) readZip(path string) (*zip.Reader, error) {
+	f, err := writer.openExisting(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, writeError{path, err.Error()}
+	}
+
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, writeError{path, err.Error()}
+	}
+
+	r.RegisterDecompressor(zstd.ZipMethodWinZip, zstd.ZipDecompressor())
+
+	return r, nil
+}
+
+// readExistingTables reads the given files of an existing feed, from
+// the directory at path or from archive, files which do not exist are
+// nil
+func (writer *Writer) readExistingTables(path string, archive *zip.Reader, names []string) (map[string]*csvTable, error) {
+	ret := make(map[string]*csvTable)
+
+	if archive == nil {
+		for _, name := range names {
+			f, err := writer.openExisting(opath.Join(path, name))
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			t, err := readCsvTable(f)
+			f.Close()
+			if err != nil {
+				return nil, writeError{name, err.Error()}
+			}
+			ret[name] = t
+		}
+		return ret, nil
+	}
+
+	for _, f := range archive.File {
+		for _, name := range names {
+			if f.Name != name {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			t, err := readCsvTable(rc)
+			rc.Close()
+			if err != nil {
+				return nil, writeError{name, err.Error()}
+			}
+			ret[name] = t
+		}
+	}
+
+	return ret, nil
+}
+
+// appended returns the header and rows of an appended file, the
+// existing rows followed by the new ones
+func appended(t *csvTable, n *csvTable) ([]string, [][]string) {
+	if t == nil {
+		return n.header, n.rows
+	}
+
+	rows, _ := t.align(n)
+
+	return t.header, append(t.rows, rows...)
+}
+
+// writeCsvTable writes a header and rows with the quote policy of the
+// writer, all columns are written
+func (writer *Writer) writeCsvTable(w io.Writer, header []string, rows [][]string) error {
+	csvwriter := NewCsvWriter(w)
+	csvwriter.SetQuote(writer.Quoting)
+	csvwriter.SetHeader(header, header)
+	csvwriter.WriteHeader()
+
+	for _, row := range rows {
+		// rows of the existing files may be ragged
+		for len(row) < len(header) {
+			row = append(row, "")
+		}
+		csvwriter.WriteCsvLineRaw(row[:len(header)])
+	}
+
+	return csvwriter.FlushFile()
+}
+
+func (writer *Writer) appendToDir(path string, existing map[string]*csvTable, fresh map[string]*csvTable) error {
+	for _, name := range appendFiles {
+		if len(fresh[name].rows) == 0 {
+			continue
+		}
+
+		header, rows := appended(existing[name], fresh[name])
+
+		f, err := writer.backend().Create(opath.Join(path, name))
+		if err != nil {
+			return writeError{name, err.Error()}
+		}
+
+		err = writer.writeCsvTable(f, header, rows)
+		if err == nil && writer.Durable {
+			err = syncFile(f)
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return writeError{name, err.Error()}
+		}
+	}
+
+	return nil
+}
+
+// appendToZip rewrites the ZIP file at path with the appended files.
+// On the local file system, the new archive is written to a temporary
+// file which replaces the existing one once it is complete.
+func (writer *Writer) appendToZip(path string, archive *zip.Reader, existing map[string]*csvTable, fresh map[string]*csvTable) error {
+	out := path
+	if writer.local() {
+		out = path + ".tmp"
+	}
+
+	f, err := writer.backend().Create(out)
+	if err != nil {
+		return writeError{path, err.Error()}
+	}
+
+	zw, err := writer.newZipWriter(f)
+
+	if err == nil && len(writer.ZipComment) == 0 {
+		err = zw.SetComment(archive.Comment)
+	}
+
+	if err == nil {
+		err = writer.rewriteZip(archive, zw, existing, fresh)
+	}
+
+	if zw != nil {
+		if cerr := zw.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err == nil && writer.Durable {
+		err = syncFile(f)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		if out != path {
+			writer.backend().Remove(out)
+		}
+		return err
+	}
+
+	if out != path {
+		return os.Rename(out, path)
+	}
+
+	return nil
+}
+
+func (writer *Writer) rewriteZip(r *zip.Reader, zw *zip.Writer, existing map[string]*csvTable, fresh map[string]*csvTable) error {
+	touched := make(map[string]bool)
+	for _, name := range appendFiles {
+		if len(fresh[name].rows) > 0 {
+			touched[name] = true
+		}
+	}
+
+	for _, f := range r.File {
+		if touched[f.Name] {
+			continue
+		}
+		if err := zw.Copy(f); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range appendFiles {
+		if !touched[name] {
+			continue
+		}
+
+		header, rows := appended(existing[name], fresh[name])
+
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: writer.zipMethod(), Modified: writer.ZipModified})
+		if err != nil {
+			return err
+		}
+		if err := writer.writeCsvTable(w, header, rows); err != nil {
+			return writeError{name, err.Error()}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// atomic checks whether the output is written to a temporary path and
+// renamed into place, which is only possible on the local file system
+func (writer *Writer) atomic() bool {
+	return writer.Atomic && writer.local()
+}
+
+// atomicTemp creates the temporary directory or file a write to path
+// goes to, next to path and with its permissions
+func (writer *Writer) atomicTemp(path string) (string, error) {
+	if writer.SkipUnchanged {
+		return "", writeError{path, "Atomic cannot be used with SkipUnchanged"}
+	}
+
+	fileInfo, e := os.Stat(path)
+	if e != nil {
+		return "", e
+	}
+
+	if fileInfo.IsDir() && (len(writer.OnlyFiles) > 0 || len(writer.SkipFiles) > 0 || writer.PartialFeed == PartialSkip) {
+		// files not written would be removed with the swapped directory
+		return "", writeError{path, "Atomic cannot be used with OnlyFiles, SkipFiles or PartialSkip for an output directory"}
+	}
+
+	pattern := "." + filepath.Base(path) + ".tmp-*"
+
+	var tmp string
+	if fileInfo.IsDir() {
+		tmp, e = os.MkdirTemp(filepath.Dir(path), pattern)
+	} else {
+		var f *os.File
+		f, e = os.CreateTemp(filepath.Dir(path), pattern)
+		if e == nil {
+			tmp = f.Name()
+			e = f.Close()
+		}
+	}
+
+	if e != nil {
+		return "", writeError{path, e.Error()}
+	}
+
+	if e := os.Chmod(tmp, fileInfo.Mode().Perm()); e != nil {
+		os.RemoveAll(tmp)
+		return "", writeError{path, e.Error()}
+	}
+
+	return tmp, nil
+}
+
+// commitAtomic moves the completely written output at tmp to path. A
+// ZIP file replaces the existing file in a single rename, a directory
+// is swapped with the existing one, which is then removed.
+func (writer *Writer) commitAtomic(tmp string, path string) error {
+	fileInfo, e := os.Stat(tmp)
+	if e != nil {
+		return writeError{path, e.Error()}
+	}
+
+	if !fileInfo.IsDir() {
+		if e := os.Rename(tmp, path); e != nil {
+			return writeError{path, e.Error()}
+		}
+	} else {
+		old := tmp + ".old"
+		if e := os.Rename(path, old); e != nil {
+			return writeError{path, e.Error()}
+		}
+		if e := os.Rename(tmp, path); e != nil {
+			// restore the previous output
+			os.Rename(old, path)
+			return writeError{path, e.Error()}
+		}
+		if e := os.RemoveAll(old); e != nil {
+			return writeError{path, e.Error()}
+		}
+	}
+
+	if writer.Durable {
+		return writer.syncDir(filepath.Dir(path))
+	}
+
+	return nil
+}
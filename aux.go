@@ -0,0 +1,107 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"bytes"
+	"io"
+	opath "path"
+	"sort"
+)
+
+// an extraFile is a file added with AddExtraFile
+type extraFile struct {
+	name string
+	data []byte
+}
+
+// AddExtraFile adds a file whose contents are read from r into the
+// output of the next call to Write, e.g. a non-standard file of the
+// input feed like a README.txt. r is read completely when the file is
+// added. Extra files are written after the auxiliary files, in the
+// order they were added, and are dropped after the write. The same
+// restrictions on names as for AuxFiles apply.
+func (writer *Writer) AddExtraFile(name string, r io.Reader) error {
+	data, e := io.ReadAll(r)
+	if e != nil {
+		return writeError{name, e.Error()}
+	}
+
+	writer.extraFiles = append(writer.extraFiles, extraFile{name, data})
+
+	return nil
+}
+
+// writeAuxFiles writes the auxiliary files given in AuxFiles, ordered
+// by name, and the extra files added with AddExtraFile
+func (writer *Writer) writeAuxFiles(path string) error {
+	names := make([]string, 0, len(writer.AuxFiles))
+	for name := range writer.AuxFiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	gtfsFiles := make(map[string]bool)
+	for _, t := range writer.tables(nil) {
+		gtfsFiles[t.name] = true
+	}
+
+	for _, name := range names {
+		if e := writer.writeAuxFile(path, name, bytes.NewReader(writer.AuxFiles[name]), gtfsFiles); e != nil {
+			return e
+		}
+	}
+
+	written := make(map[string]bool, len(writer.extraFiles))
+	for _, name := range names {
+		written[name] = true
+	}
+
+	for _, f := range writer.extraFiles {
+		if written[f.name] {
+			return writeError{f.name, "extra file is written twice"}
+		}
+		written[f.name] = true
+
+		if e := writer.writeAuxFile(path, f.name, bytes.NewReader(f.data), gtfsFiles); e != nil {
+			return e
+		}
+	}
+
+	return nil
+}
+
+// writeAuxFile copies the contents of an auxiliary file from r into
+// the output
+func (writer *Writer) writeAuxFile(path string, name string, r io.Reader, gtfsFiles map[string]bool) error {
+	if len(name) == 0 || opath.IsAbs(name) || opath.Clean(name) != name || name == ".." || len(name) > 2 && name[:3] == "../" {
+		return writeError{name, "invalid auxiliary file name"}
+	}
+
+	if gtfsFiles[name] {
+		return writeError{name, "auxiliary file would replace a GTFS file"}
+	}
+
+	if fi, e := writer.backend().Stat(path); writer.single == nil && writer.extZip == nil && e == nil && fi.IsDir() {
+		// the directory may not exist yet for nested names
+		if e := writer.mkdirs(path, name); e != nil {
+			return writeError{name, e.Error()}
+		}
+	}
+
+	file, e := writer.getFileForWriting(path, name)
+
+	if e != nil {
+		return writeError{name, e.Error()}
+	}
+
+	if _, e := io.Copy(file, r); e != nil {
+		return writeError{name, e.Error()}
+	}
+
+	return writer.closeFile()
+}
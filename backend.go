@@ -0,0 +1,118 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// An OutputBackend is the storage a feed is written to. Names are
+// output paths, or paths of files in an output directory joined with
+// forward slashes. Backends which support directories can implement
+// MkdirAll(name string, perm fs.FileMode) error, backends which can
+// read files Open(name string) (io.ReadCloser, error) for
+// SkipUnchanged, and created files implementing Sync() error are
+// synced if Durable is set.
+type OutputBackend interface {
+	Create(name string) (io.WriteCloser, error)
+	Remove(name string) error
+	Stat(name string) (fs.FileInfo, error)
+}
+
+// osBackend writes to the local file system
+type osBackend struct{}
+
+func (osBackend) Create(name string) (io.WriteCloser, error) {
+	f, e := os.Create(name)
+	if e != nil {
+		return nil, e
+	}
+	return f, nil
+}
+
+func (osBackend) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osBackend) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osBackend) Open(name string) (io.ReadCloser, error) {
+	return os.Open(name)
+}
+
+func (osBackend) MkdirAll(name string, perm fs.FileMode) error {
+	return os.MkdirAll(name, perm)
+}
+
+// backend returns the OutputBackend written to, the local file system
+// by default
+func (writer *Writer) backend() OutputBackend {
+	if writer.DryRun {
+		return discardBackend{}
+	}
+	if writer.mem != nil {
+		return writer.mem
+	}
+	if writer.Backend != nil {
+		return writer.Backend
+	}
+	return osBackend{}
+}
+
+// writeSidecar writes a file accompanying the output, e.g. the
+// MetadataFile, through the backend. With Atomic, it is written to a
+// temporary file which is renamed into place once it is complete.
+func (writer *Writer) writeSidecar(name string, data []byte) error {
+	out := name
+	if writer.atomic() {
+		tmp, e := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".tmp-*")
+		if e != nil {
+			return writeError{name, e.Error()}
+		}
+		out = tmp.Name()
+		tmp.Close()
+	}
+
+	f, e := writer.backend().Create(out)
+	if e == nil {
+		_, e = f.Write(data)
+		if e == nil && writer.Durable {
+			e = syncFile(f)
+		}
+		if ce := f.Close(); e == nil {
+			e = ce
+		}
+	}
+
+	if e == nil && out != name {
+		if e = os.Chmod(out, 0644); e == nil {
+			e = os.Rename(out, name)
+		}
+	}
+
+	if e != nil {
+		if out != name {
+			os.Remove(out)
+		}
+		return writeError{name, e.Error()}
+	}
+
+	return nil
+}
+
+// syncFile syncs a file created by the backend, if it supports it
+func syncFile(f io.WriteCloser) error {
+	if s, ok := f.(interface{ Sync() error }); ok {
+		return s.Sync()
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// BatchReport holds the results of a call to WriteAll, keyed by output
+// path
+type BatchReport struct {
+	// reports of all written feeds, including failed ones
+	Reports map[string]Report
+
+	// errors of the feeds which could not be written
+	Errors map[string]error
+
+	// total time spent writing all feeds
+	Duration time.Duration
+}
+
+// Rows returns the total number of rows written to all feeds
+func (br BatchReport) Rows() int {
+	rows := 0
+	for _, r := range br.Reports {
+		for _, f := range r.Files {
+			rows += f.Rows
+		}
+	}
+	return rows
+}
+
+// Bytes returns the total number of uncompressed bytes written to all
+// feeds
+func (br BatchReport) Bytes() int64 {
+	bytes := int64(0)
+	for _, r := range br.Reports {
+		for _, f := range r.Files {
+			bytes += f.Bytes
+		}
+	}
+	return bytes
+}
+
+// WriteAll writes many feeds concurrently, each to the output path it
+// is keyed by. At most BatchWorkers feeds are written at the same time,
+// by default one per CPU. Every feed is written with a copy of the
+// writer's settings, the writer itself and its Report are not modified.
+// A failing feed does not stop the others, its error is recorded in the
+// returned report.
+func (writer *Writer) WriteAll(feeds map[string]*gtfsparser.Feed) BatchReport {
+	br := BatchReport{
+		Reports: make(map[string]Report, len(feeds)),
+		Errors:  make(map[string]error),
+	}
+
+	workers := writer.BatchWorkers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	start := time.Now()
+
+	paths := make(chan string)
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				w := writer.clone()
+				e := w.Write(feeds[path], path)
+
+				mutex.Lock()
+				br.Reports[path] = w.Report
+				if e != nil {
+					br.Errors[path] = e
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	for path := range feeds {
+		paths <- path
+	}
+	close(paths)
+
+	wg.Wait()
+
+	br.Duration = time.Since(start)
+
+	return br
+}
@@ -0,0 +1,63 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+)
+
+// bikesAllowed returns the bikes_allowed value written for a trip. If
+// the trip has no value, the default for its route type from
+// BikesAllowedDefaults is used. Defaults for extended route types take
+// precedence over defaults for the basic route type they belong to.
+func (writer *Writer) bikesAllowed(t *gtfs.Trip) int8 {
+	if t.Bikes_allowed != 0 || len(writer.BikesAllowedDefaults) == 0 {
+		return t.Bikes_allowed
+	}
+
+	typ := writer.route(t.Route).Type
+
+	if ba, ok := writer.BikesAllowedDefaults[typ]; ok {
+		return ba
+	}
+
+	if basic := basicRouteType(typ); basic != typ {
+		if ba, ok := writer.BikesAllowedDefaults[basic]; ok {
+			return ba
+		}
+	}
+
+	return 0
+}
+
+// basicRouteType returns the basic GTFS route type an extended
+// route type belongs to, or the type itself if there is none
+func basicRouteType(typ int16) int16 {
+	switch {
+	case typ >= 100 && typ < 200:
+		return 2
+	case typ >= 200 && typ < 300:
+		return 3
+	case typ == 405:
+		return 12
+	case typ >= 400 && typ < 500:
+		return 1
+	case typ >= 700 && typ < 800:
+		return 3
+	case typ == 800:
+		return 11
+	case typ >= 900 && typ < 1000:
+		return 0
+	case typ >= 1000 && typ < 1100, typ >= 1200 && typ < 1300:
+		return 4
+	case typ >= 1300 && typ < 1400:
+		return 6
+	case typ >= 1400 && typ < 1500:
+		return 7
+	}
+	return typ
+}
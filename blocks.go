@@ -0,0 +1,75 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"sort"
+	"strconv"
+)
+
+// prepareBlockIds builds the mapping of original block IDs to the
+// block IDs written, according to CompactBlockIds and DropSingleTripBlocks
+func (writer *Writer) prepareBlockIds(feed *gtfsparser.Feed) {
+	writer.blockIds = nil
+
+	if !writer.CompactBlockIds && !writer.DropSingleTripBlocks {
+		return
+	}
+
+	count := make(map[string]int)
+	for _, t := range feed.Trips {
+		if t.Block_id != nil && len(*t.Block_id) > 0 {
+			count[*t.Block_id]++
+		}
+	}
+
+	ids := make([]string, 0, len(count))
+	for id := range count {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	writer.blockIds = make(map[string]string, len(ids))
+
+	n := 0
+	for _, id := range ids {
+		if writer.DropSingleTripBlocks && count[id] == 1 {
+			writer.blockIds[id] = ""
+		} else if writer.CompactBlockIds {
+			n++
+			writer.blockIds[id] = strconv.Itoa(n)
+		} else {
+			writer.blockIds[id] = id
+		}
+	}
+
+	// changes are recorded for each trip of an altered block
+	for _, t := range feed.Trips {
+		if t.Block_id == nil || len(*t.Block_id) == 0 {
+			continue
+		}
+		id := *t.Block_id
+		if writer.blockIds[id] == "" {
+			writer.change("trips.txt", t.Id, "block_id", ChangeDropped, id, "")
+		} else if writer.blockIds[id] != id {
+			writer.change("trips.txt", t.Id, "block_id", ChangeModified, id, writer.blockIds[id])
+		}
+	}
+}
+
+// blockId returns the block ID written for a trip
+func (writer *Writer) blockId(t *gtfs.Trip) string {
+	if t.Block_id == nil {
+		return ""
+	}
+	if writer.blockIds == nil {
+		return *t.Block_id
+	}
+	return writer.blockIds[*t.Block_id]
+}
@@ -0,0 +1,18 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+// A FalseValue defines how false is written in an optional boolean
+// column. Required boolean columns always hold an explicit 0.
+type FalseValue int
+
+const (
+	// FalseEmpty writes false as an empty value
+	FalseEmpty FalseValue = iota
+	// FalseExplicit writes false as 0
+	FalseExplicit
+)
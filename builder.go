@@ -0,0 +1,359 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"io"
+	"os"
+)
+
+// A Builder writes a feed whose entities are added one by one, without
+// building a complete Feed first. Stop times, which usually make up the
+// bulk of a feed, are serialized as soon as they are added and never
+// kept in memory. All other entities are small and buffered until Close
+// writes the feed.
+type Builder struct {
+	writer    *Writer
+	path      string
+	feed      *gtfsparser.Feed
+	stopTimes *stream
+	shapes    *stream
+	err       error
+}
+
+// a stream holds the rows of a table serialized by a Builder in a
+// temporary file, together with the columns they use
+type stream struct {
+	file   *os.File
+	writer *csv.Writer
+	usage  []bool
+	row    []string
+	rows   int
+}
+
+// NewBuilder returns a Builder which writes to path on Close. Write-time
+// transformations and checks which need the stop times of a trip (stop
+// merging, trip ID templates, filtering, shape generation, headsign
+// filling and the stop time based checks) cannot be applied to streamed
+// stop times and are rejected.
+func (writer *Writer) NewBuilder(path string) (*Builder, error) {
+	if writer.MergeStopsDist > 0 || len(writer.TripIdTemplate) > 0 {
+		return nil, errors.New("stop merging and trip ID templates are not supported by the builder")
+	}
+
+	if writer.Filter.active() {
+		return nil, errors.New("filters are not supported by the builder")
+	}
+
+	if writer.GenerateShapes || writer.FillHeadsigns {
+		return nil, errors.New("shape generation and headsign filling are not supported by the builder")
+	}
+
+	if writer.StopTimesCheck != CheckOff || writer.ConflictCheck != CheckOff || writer.ShapeDistCheck != CheckOff || writer.WheelchairCheck != CheckOff || writer.DowngradeWheelchair {
+		return nil, errors.New("stop time checks are not supported by the builder")
+	}
+
+	stCols := 12
+	if writer.SecondsColumns {
+		stCols += 2
+	}
+
+	stopTimes, e := newStream("stop_times", stCols)
+	if e != nil {
+		return nil, e
+	}
+
+	shapes, e := newStream("shapes", 5)
+	if e != nil {
+		stopTimes.close()
+		return nil, e
+	}
+
+	return &Builder{
+		writer:    writer,
+		path:      path,
+		feed:      gtfsparser.NewFeed(),
+		stopTimes: stopTimes,
+		shapes:    shapes,
+	}, nil
+}
+
+// Feed returns the buffered feed. Entities for which the Builder has no
+// Add method, like transfers or fares, can be added to it directly.
+func (b *Builder) Feed() *gtfsparser.Feed {
+	return b.feed
+}
+
+// AddAgency adds an agency
+func (b *Builder) AddAgency(a *gtfs.Agency) error {
+	return b.add("agency.txt", a.Id, b.feed.Agencies[a.Id] != nil, func() { b.feed.Agencies[a.Id] = a })
+}
+
+// AddStop adds a stop
+func (b *Builder) AddStop(s *gtfs.Stop) error {
+	return b.add("stops.txt", s.Id, b.feed.Stops[s.Id] != nil, func() { b.feed.Stops[s.Id] = s })
+}
+
+// AddRoute adds a route
+func (b *Builder) AddRoute(r *gtfs.Route) error {
+	return b.add("routes.txt", r.Id, b.feed.Routes[r.Id] != nil, func() { b.feed.Routes[r.Id] = r })
+}
+
+// AddService adds a service, which is written to calendar.txt and
+// calendar_dates.txt
+func (b *Builder) AddService(s *gtfs.Service) error {
+	return b.add("calendar.txt", s.Id(), b.feed.Services[s.Id()] != nil, func() { b.feed.Services[s.Id()] = s })
+}
+
+// AddShape adds a shape, including its points. Shapes with many points
+// can be streamed with AddShapePoint instead.
+func (b *Builder) AddShape(s *gtfs.Shape) error {
+	return b.add("shapes.txt", s.Id, b.feed.Shapes[s.Id] != nil, func() { b.feed.Shapes[s.Id] = s })
+}
+
+// AddTrip adds a trip. Its stop times have to be added with AddStopTime,
+// stop times already attached to the trip are ignored.
+func (b *Builder) AddTrip(t *gtfs.Trip) error {
+	return b.add("trips.txt", t.Id, b.feed.Trips[t.Id] != nil, func() { b.feed.Trips[t.Id] = t })
+}
+
+// AddStopTime adds a stop time of trip t, which must have been added
+// before. The stop time is written immediately.
+func (b *Builder) AddStopTime(t *gtfs.Trip, st gtfs.StopTime) error {
+	if b.err != nil {
+		return b.err
+	}
+
+	if b.feed.Trips[t.Id] != t {
+		b.err = writeError{"stop_times.txt", fmt.Sprintf("trip '%s' has not been added", t.Id)}
+		return b.err
+	}
+
+	b.writer.stopTimeLine(t, &st, b.stopTimes.row)
+	if b.writer.SecondsColumns {
+		stopTimeSeconds(&st, b.stopTimes.row[12:])
+	}
+
+	if e := b.stopTimes.write(); e != nil {
+		b.err = writeError{"stop_times.txt", e.Error()}
+	}
+
+	return b.err
+}
+
+// AddShapePoint adds a point of shape s. The point is written
+// immediately, s itself must not be added with AddShape.
+func (b *Builder) AddShapePoint(s *gtfs.Shape, p gtfs.ShapePoint) error {
+	if b.err != nil {
+		return b.err
+	}
+
+	if b.feed.Shapes[s.Id] != nil {
+		b.err = writeError{"shapes.txt", fmt.Sprintf("shape '%s' has been added with AddShape", s.Id)}
+		return b.err
+	}
+
+	b.writer.shapePointLine(s, &p, b.shapes.row)
+
+	if e := b.shapes.write(); e != nil {
+		b.err = writeError{"shapes.txt", e.Error()}
+	}
+
+	return b.err
+}
+
+// Close writes the feed to the Builder's path. All write-time
+// transformations and checks are applied to the buffered entities as in
+// Write, stop times and streamed shape points are written in the order
+// they were added.
+func (b *Builder) Close() error {
+	defer b.stopTimes.close()
+	defer b.shapes.close()
+
+	if b.err != nil {
+		return b.err
+	}
+
+	b.writer.streamed = b
+	defer func() { b.writer.streamed = nil }()
+
+	b.err = b.writer.Write(b.feed, b.path)
+
+	if b.err == nil {
+		b.err = errors.New("builder is closed")
+		return nil
+	}
+
+	return b.err
+}
+
+// add registers an entity through set, unless an entity with the same
+// ID has already been added
+func (b *Builder) add(file string, id string, exists bool, set func()) error {
+	if b.err != nil {
+		return b.err
+	}
+
+	if exists {
+		return writeError{file, fmt.Sprintf("ID '%s' has already been added", id)}
+	}
+
+	set()
+
+	return nil
+}
+
+// newStream creates a stream for rows with the given number of columns
+func newStream(name string, cols int) (*stream, error) {
+	f, e := os.CreateTemp("", "gtfswriter-"+name+"-*.csv")
+	if e != nil {
+		return nil, e
+	}
+
+	return &stream{
+		file:   f,
+		writer: csv.NewWriter(f),
+		usage:  make([]bool, cols),
+		row:    make([]string, cols),
+	}, nil
+}
+
+// write serializes the current row
+func (s *stream) write() error {
+	for i, v := range s.row {
+		if len(v) > 0 {
+			s.usage[i] = true
+		}
+	}
+
+	if e := s.writer.Write(s.row); e != nil {
+		return e
+	}
+
+	s.rows++
+
+	return nil
+}
+
+// headerUsage marks the columns used by the streamed rows as used in
+// csvwriter, whose header has at least as many columns
+func (s *stream) headerUsage(csvwriter *CsvWriter) {
+	usage := make([]string, len(s.usage))
+	for i, used := range s.usage {
+		if used {
+			usage[i] = "-"
+		}
+	}
+	csvwriter.HeaderUsage(usage)
+}
+
+// copy writes the streamed rows to csvwriter, padded to cols columns,
+// or buffers them in csvwriter if buffered is set
+func (s *stream) copy(csvwriter *CsvWriter, cols int, buffered bool) error {
+	s.writer.Flush()
+	if e := s.writer.Error(); e != nil {
+		return e
+	}
+
+	if _, e := s.file.Seek(0, io.SeekStart); e != nil {
+		return e
+	}
+
+	reader := csv.NewReader(s.file)
+
+	for {
+		row, e := reader.Read()
+		if e == io.EOF {
+			return nil
+		}
+		if e != nil {
+			return e
+		}
+		for len(row) < cols {
+			row = append(row, "")
+		}
+		if buffered {
+			csvwriter.WriteCsvLine(row)
+		} else if e := csvwriter.WriteCsvLineRaw(row); e != nil {
+			return e
+		}
+	}
+}
+
+// close removes the temporary file of the stream
+func (s *stream) close() {
+	s.file.Close()
+	os.Remove(s.file.Name())
+}
+
+// writeStreamedStopTimes copies the stop times streamed by a Builder
+// into stop_times.txt, without the columns no stop time used
+func (writer *Writer) writeStreamedStopTimes(path string, feed *gtfsparser.Feed) (err error) {
+	st := writer.streamed.stopTimes
+
+	if writer.PartialFeed == PartialSkip && st.rows == 0 {
+		return writer.delExistingFile(path, "stop_times.txt")
+	}
+
+	file, e := writer.getFileForWriting(path, "stop_times.txt")
+
+	if e != nil {
+		return errors.New("Could not open required file stop_times.txt for writing")
+	}
+
+	csvwriter := writer.newCsvWriter(file, "stop_times.txt")
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = writer.recovered("stop_times.txt", r)
+		}
+	}()
+
+	header := StopTimeColumns()
+
+	if writer.SecondsColumns {
+		header = append(header, "arrival_secs", "departure_secs")
+	}
+
+	csvwriter.SetHeader(header,
+		[]string{"trip_id", "arrival_time", "departure_time", "stop_id", "stop_sequence"})
+
+	if writer.KeepColOrder {
+		csvwriter.SetOrder(feed.ColOrders.StopTimes)
+	}
+
+	st.headerUsage(&csvwriter)
+
+	keyed := writer.keyed("stop_times.txt")
+
+	if !keyed {
+		csvwriter.WriteHeader()
+	}
+
+	if ce := st.copy(&csvwriter, len(header), keyed); ce != nil {
+		return writeError{"stop_times.txt", ce.Error()}
+	}
+
+	if keyed {
+		writer.sortLines(&csvwriter, "stop_times.txt", 0)
+		if fe := csvwriter.Flush(); fe != nil {
+			return writeError{"stop_times.txt", fe.Error()}
+		}
+		return e
+	}
+
+	if fe := csvwriter.FlushFile(); fe != nil {
+		return writeError{"stop_times.txt", fe.Error()}
+	}
+
+	return e
+}
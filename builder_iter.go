@@ -0,0 +1,36 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+//go:build go1.23
+
+package gtfswriter
+
+import (
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"iter"
+)
+
+// AddStopTimes adds all stop times of trip t produced by seq, e.g.
+// straight from a database cursor. Iteration stops at the first error.
+func (b *Builder) AddStopTimes(t *gtfs.Trip, seq iter.Seq[gtfs.StopTime]) error {
+	for st := range seq {
+		if e := b.AddStopTime(t, st); e != nil {
+			return e
+		}
+	}
+	return b.err
+}
+
+// AddShapePoints adds all points of shape s produced by seq. Iteration
+// stops at the first error.
+func (b *Builder) AddShapePoints(s *gtfs.Shape, seq iter.Seq[gtfs.ShapePoint]) error {
+	for p := range seq {
+		if e := b.AddShapePoint(s, p); e != nil {
+			return e
+		}
+	}
+	return b.err
+}
@@ -0,0 +1,65 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+)
+
+// prepareCalendars collects the services with a weekly pattern which
+// are written to calendar_dates.txt only, because they have fewer than
+// CalendarMinDays regular days
+func (writer *Writer) prepareCalendars(feed *gtfsparser.Feed) {
+	writer.datesOnly = make(map[*gtfs.Service]bool)
+
+	if writer.CalendarMinDays <= 0 {
+		return
+	}
+
+	for _, s := range feed.Services {
+		if s.RawDaymap() == 0 || regularDays(s) >= writer.CalendarMinDays {
+			continue
+		}
+
+		// a service without any active day keeps its calendar row,
+		// it would vanish otherwise
+		if len(activeDates(s)) > 0 {
+			writer.datesOnly[s] = true
+		}
+	}
+}
+
+// calendarRow checks whether a service is written with its weekly
+// pattern to calendar.txt
+func (writer *Writer) calendarRow(s *gtfs.Service) bool {
+	return (s.RawDaymap() > 0 && !writer.datesOnly[s]) || s.IsEmpty()
+}
+
+// regularDays returns the number of days between the start and end
+// date of a service matching its weekly pattern
+func regularDays(s *gtfs.Service) int {
+	n := 0
+	for d := s.Start_date(); !d.GetTime().After(s.End_date().GetTime()); d = d.GetOffsettedDate(1) {
+		if s.Daymap(int(d.GetTime().Weekday())) {
+			n++
+		}
+	}
+	return n
+}
+
+// activeDates returns all dates a service is active on, in order
+func activeDates(s *gtfs.Service) []gtfs.Date {
+	dates := make([]gtfs.Date, 0)
+	last := s.GetLastDefinedDate()
+	for d := s.GetFirstDefinedDate(); !d.GetTime().After(last.GetTime()); d = d.GetOffsettedDate(1) {
+		if s.IsActiveOn(d) {
+			dates = append(dates, d)
+		}
+	}
+	return dates
+}
@@ -0,0 +1,149 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"sort"
+	"strings"
+)
+
+// A ChangeAction describes how the writer altered an entity
+type ChangeAction int
+
+const (
+	// ChangeDropped means the entity was not written
+	ChangeDropped ChangeAction = iota
+	// ChangeMerged means the entity was merged into another entity
+	ChangeMerged
+	// ChangeModified means a field of the entity was altered
+	ChangeModified
+	// ChangeFilled means an empty field of the entity was filled
+	ChangeFilled
+	// ChangeCreated means the entity was generated by the writer
+	ChangeCreated
+)
+
+func (a ChangeAction) String() string {
+	switch a {
+	case ChangeDropped:
+		return "dropped"
+	case ChangeMerged:
+		return "merged"
+	case ChangeModified:
+		return "modified"
+	case ChangeFilled:
+		return "filled"
+	case ChangeCreated:
+		return "created"
+	}
+	return "unknown"
+}
+
+// MarshalText encodes a ChangeAction as its name
+func (a ChangeAction) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// A Change describes a single entity the writer dropped, merged,
+// modified, filled or created. Old and New hold the affected values,
+// if any.
+type Change struct {
+	File     string       `json:"file"`
+	EntityId string       `json:"entity_id"`
+	Field    string       `json:"field,omitempty"`
+	Action   ChangeAction `json:"action"`
+	Old      string       `json:"old_value,omitempty"`
+	New      string       `json:"new_value,omitempty"`
+}
+
+type changes []Change
+
+func (c changes) Len() int      { return len(c) }
+func (c changes) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c changes) Less(i, j int) bool {
+	if c[i].File != c[j].File {
+		return c[i].File < c[j].File
+	}
+	if c[i].EntityId != c[j].EntityId {
+		return c[i].EntityId < c[j].EntityId
+	}
+	if c[i].Field != c[j].Field {
+		return c[i].Field < c[j].Field
+	}
+	return c[i].Action < c[j].Action
+}
+
+// recordChanges checks whether changes should be collected
+func (writer *Writer) recordChanges() bool {
+	return writer.RecordChanges || len(writer.ChangeReportFile) > 0
+}
+
+// change records a change to an entity, if changes are collected
+func (writer *Writer) change(file string, id string, field string, action ChangeAction, old string, new string) {
+	if !writer.recordChanges() {
+		return
+	}
+	writer.Report.Changes = append(writer.Report.Changes, Change{file, id, field, action, old, new})
+}
+
+// transferId returns an identifier for a transfer, which has no ID
+func transferId(tk gtfs.TransferKey) string {
+	ids := make([]string, 0, 2)
+	for _, s := range []*gtfs.Stop{tk.From_stop, tk.To_stop} {
+		if s != nil {
+			ids = append(ids, s.Id)
+		} else {
+			ids = append(ids, "")
+		}
+	}
+	return strings.Join(ids, ":")
+}
+
+// writeChangeReport writes the collected changes to ChangeReportFile,
+// as JSON if the file name ends with .json, and as CSV otherwise
+func (writer *Writer) writeChangeReport() error {
+	if len(writer.ChangeReportFile) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+
+	if strings.HasSuffix(strings.ToLower(writer.ChangeReportFile), ".json") {
+		list := writer.Report.Changes
+		if list == nil {
+			list = []Change{}
+		}
+		enc := json.NewEncoder(&buf)
+		enc.SetIndent("", "  ")
+		if e := enc.Encode(list); e != nil {
+			return writeError{writer.ChangeReportFile, e.Error()}
+		}
+		return writer.writeSidecar(writer.ChangeReportFile, buf.Bytes())
+	}
+
+	csvwriter := csv.NewWriter(&buf)
+	csvwriter.Write([]string{"file", "entity_id", "field", "action", "old_value", "new_value"})
+	for _, c := range writer.Report.Changes {
+		csvwriter.Write([]string{c.File, c.EntityId, c.Field, c.Action.String(), c.Old, c.New})
+	}
+	csvwriter.Flush()
+
+	if e := csvwriter.Error(); e != nil {
+		return writeError{writer.ChangeReportFile, e.Error()}
+	}
+
+	return writer.writeSidecar(writer.ChangeReportFile, buf.Bytes())
+}
+
+// sortChanges brings the collected changes into a deterministic order
+func (writer *Writer) sortChanges() {
+	sort.Stable(changes(writer.Report.Changes))
+}
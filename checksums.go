@@ -0,0 +1,73 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+)
+
+// checksums checks whether SHA-256 checksums of the written files
+// are computed
+func (writer *Writer) checksums() bool {
+	return writer.Checksums || len(writer.ChecksumFile) > 0
+}
+
+// hashArchive returns a writer which passes all writes to the ZIP
+// file out on, and hashes them into archiveHash
+func (writer *Writer) hashArchive(out io.Writer) io.Writer {
+	if !writer.checksums() {
+		return out
+	}
+
+	writer.archiveHash = sha256.New()
+	return io.MultiWriter(out, writer.archiveHash)
+}
+
+// finishChecksums sets the checksums of the written files, and of the
+// ZIP file written to path
+func (writer *Writer) finishChecksums() {
+	for _, fs := range writer.Report.Files {
+		if fs.sum != nil {
+			fs.Sha256 = hex.EncodeToString(fs.sum.Sum(nil))
+			fs.sum = nil
+		}
+	}
+
+	if writer.archiveHash != nil {
+		writer.Report.ArchiveSha256 = hex.EncodeToString(writer.archiveHash.Sum(nil))
+		writer.archiveHash = nil
+	}
+}
+
+// writeChecksumFile writes the checksums of the written files, and of
+// the archive named archive if one was written, to ChecksumFile in the
+// format of sha256sum. Nothing is written if the output is discarded.
+func (writer *Writer) writeChecksumFile(archive string) error {
+	if len(writer.ChecksumFile) == 0 {
+		return nil
+	}
+
+	if _, ok := writer.backend().(discardBackend); ok {
+		return nil
+	}
+
+	var buf bytes.Buffer
+
+	for _, fs := range writer.Report.Files {
+		fmt.Fprintf(&buf, "%s  %s\n", fs.Sha256, fs.Name)
+	}
+
+	if len(writer.Report.ArchiveSha256) > 0 {
+		fmt.Fprintf(&buf, "%s  %s\n", writer.Report.ArchiveSha256, archive)
+	}
+
+	return writer.writeSidecar(writer.ChecksumFile, buf.Bytes())
+}
@@ -0,0 +1,59 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"io/fs"
+	opath "path"
+)
+
+// mkdirs creates the directories of a nested file name in the output
+// directory, and records those which did not exist before
+func (writer *Writer) mkdirs(path string, name string) error {
+	dir := opath.Dir(name)
+
+	missing := make([]string, 0)
+	for d := dir; d != "." && d != "/"; d = opath.Dir(d) {
+		if _, e := writer.backend().Stat(opath.Join(path, d)); e == nil {
+			break
+		}
+		missing = append(missing, opath.Join(path, d))
+	}
+
+	mkdir, ok := writer.backend().(interface {
+		MkdirAll(name string, perm fs.FileMode) error
+	})
+	if !ok {
+		// the backend has no directories
+		return nil
+	}
+
+	if e := mkdir.MkdirAll(opath.Join(path, dir), 0755); e != nil {
+		return e
+	}
+
+	// outer directories first, so they are removed last
+	for i := len(missing) - 1; i >= 0; i-- {
+		writer.created = append(writer.created, missing[i])
+	}
+
+	return nil
+}
+
+// cleanup removes the files and directories created by a failed write,
+// if CleanupOnError is set
+func (writer *Writer) cleanup() {
+	if !writer.CleanupOnError {
+		return
+	}
+
+	for i := len(writer.created) - 1; i >= 0; i-- {
+		writer.backend().Remove(writer.created[i])
+	}
+
+	writer.created = nil
+}
@@ -0,0 +1,61 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"github.com/klauspost/compress/zip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// A CompressionMethod is the compression of the files in a ZIP file
+type CompressionMethod int
+
+const (
+	// CompressDeflate compresses files with Deflate, which all ZIP
+	// readers support
+	CompressDeflate CompressionMethod = iota
+	// CompressZstd compresses files with Zstandard, which is much
+	// faster for large files, but not supported by all ZIP readers
+	CompressZstd
+)
+
+// zipMethod returns the ZIP method of the written files
+func (writer *Writer) zipMethod() uint16 {
+	if writer.Compression != CompressZstd {
+		return zip.Deflate
+	}
+
+	if writer.ZipCompressionLevel == -1 {
+		return zip.Store
+	}
+
+	return zstd.ZipMethodWinZip
+}
+
+// registerZstd registers the Zstandard compressor for a ZIP file,
+// with level as the zstd level if it is greater than 0
+func registerZstd(zw *zip.Writer, level int) {
+	opts := make([]zstd.EOption, 0, 1)
+	if level > 0 {
+		opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	}
+
+	zw.RegisterCompressor(zstd.ZipMethodWinZip, zstd.ZipCompressor(opts...))
+}
+
+// openZip opens a ZIP file for reading, including files compressed
+// with Zstandard
+func openZip(path string) (*zip.ReadCloser, error) {
+	r, e := zip.OpenReader(path)
+	if e != nil {
+		return nil, e
+	}
+
+	r.RegisterDecompressor(zstd.ZipMethodWinZip, zstd.ZipDecompressor())
+
+	return r, nil
+}
@@ -0,0 +1,156 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"fmt"
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"sort"
+	"strings"
+)
+
+// A Conflict describes entities which contradict each other
+type Conflict struct {
+	File string   `json:"file"`
+	Ids  []string `json:"ids"`
+	Msg  string   `json:"message"`
+}
+
+type conflicts []Conflict
+
+func (c conflicts) Len() int      { return len(c) }
+func (c conflicts) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c conflicts) Less(i, j int) bool {
+	if c[i].File != c[j].File {
+		return c[i].File < c[j].File
+	}
+	return strings.Join(c[i].Ids, "\x00") < strings.Join(c[j].Ids, "\x00")
+}
+
+type tripSpan struct {
+	trip  *gtfs.Trip
+	start int
+	end   int
+}
+
+type tripSpans []tripSpan
+
+func (ts tripSpans) Len() int      { return len(ts) }
+func (ts tripSpans) Swap(i, j int) { ts[i], ts[j] = ts[j], ts[i] }
+func (ts tripSpans) Less(i, j int) bool {
+	if ts[i].start != ts[j].start {
+		return ts[i].start < ts[j].start
+	}
+	return ts[i].trip.Id < ts[j].trip.Id
+}
+
+// checkConflicts finds trips of the same block which overlap in time
+// on a common service day, and overlapping frequency windows of trips,
+// according to the ConflictCheck policy
+func (writer *Writer) checkConflicts(feed *gtfsparser.Feed) error {
+	if writer.ConflictCheck == CheckOff {
+		return nil
+	}
+
+	found := make(conflicts, 0)
+
+	// block overlaps, trips with frequencies are not considered
+	blocks := make(map[string]tripSpans)
+	for _, t := range feed.Trips {
+		block := writer.blockId(t)
+		if len(block) == 0 || len(t.StopTimes) == 0 || (t.Frequencies != nil && len(*t.Frequencies) > 0) {
+			continue
+		}
+		start := firstDeparture(t)
+		end := timeToSeconds(t.StopTimes[len(t.StopTimes)-1].Arrival_time())
+		blocks[block] = append(blocks[block], tripSpan{t, start, end})
+	}
+
+	for block, spans := range blocks {
+		sort.Sort(spans)
+		for i := range spans {
+			for j := i + 1; j < len(spans) && spans[j].start < spans[i].end; j++ {
+				if !servicesOverlap(spans[i].trip.Service, spans[j].trip.Service) {
+					continue
+				}
+				found = append(found, Conflict{"trips.txt", []string{writer.tripId(spans[i].trip), writer.tripId(spans[j].trip)}, fmt.Sprintf("trips of block '%s' overlap in time", block)})
+			}
+		}
+	}
+
+	// frequency windows
+	for _, t := range feed.Trips {
+		if t.Frequencies == nil || len(*t.Frequencies) < 2 {
+			continue
+		}
+
+		lines := make(freqLines, 0, len(*t.Frequencies))
+		for _, f := range *t.Frequencies {
+			lines = append(lines, freqLine{f, f})
+		}
+		sort.Stable(lines)
+
+		end := timeToSeconds(lines[0].freq.End_time)
+		for _, l := range lines[1:] {
+			if timeToSeconds(l.freq.Start_time) < end {
+				found = append(found, Conflict{"frequencies.txt", []string{writer.tripId(t)}, fmt.Sprintf("window %s-%s overlaps a previous window", timeToString(l.freq.Start_time), timeToString(l.freq.End_time))})
+			}
+			if e := timeToSeconds(l.freq.End_time); e > end {
+				end = e
+			}
+		}
+	}
+
+	sort.Stable(found)
+	writer.Report.Conflicts = found
+
+	if len(found) == 0 {
+		return nil
+	}
+
+	if writer.ConflictCheck == CheckFail {
+		list := make([]string, len(found))
+		for i, c := range found {
+			list[i] = fmt.Sprintf("%s (%s)", c.Msg, strings.Join(c.Ids, ", "))
+		}
+		return writeError{found[0].File, "conflicts: " + strings.Join(list, "; ")}
+	}
+
+	for _, c := range found {
+		writer.warn(c.File, strings.Join(c.Ids, ", "), "", c.Msg)
+	}
+
+	return nil
+}
+
+// servicesOverlap checks whether two services share an active day
+func servicesOverlap(a *gtfs.Service, b *gtfs.Service) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	if a == b {
+		return !a.IsEmpty()
+	}
+
+	first := a.GetFirstDefinedDate()
+	if b.GetFirstDefinedDate().GetTime().After(first.GetTime()) {
+		first = b.GetFirstDefinedDate()
+	}
+	last := a.GetLastDefinedDate()
+	if b.GetLastDefinedDate().GetTime().Before(last.GetTime()) {
+		last = b.GetLastDefinedDate()
+	}
+
+	for d := first; !d.GetTime().After(last.GetTime()); d = d.GetOffsettedDate(1) {
+		if a.IsActiveOn(d) && b.IsActiveOn(d) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,50 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"context"
+	"fmt"
+	"github.com/patrickbr/gtfsparser"
+)
+
+// number of trips or shapes written between two checks for cancellation
+const cancelCheckInterval = 1000
+
+// WriteContext writes a feed like Write, but aborts once ctx is done.
+// Cancellation is checked before each file and periodically while
+// writing shapes and stop times. The output of an aborted write is
+// incomplete, unless CleanupOnError is set. The returned error wraps
+// the error of ctx.
+func (writer *Writer) WriteContext(ctx context.Context, feed *gtfsparser.Feed, path string) error {
+	writer.ctx = ctx
+	defer func() { writer.ctx = nil }()
+
+	return writer.Write(feed, path)
+}
+
+// canceled returns an error if the context of the current write is done
+func (writer *Writer) canceled(file string) error {
+	if writer.ctx == nil {
+		return nil
+	}
+
+	if e := writer.ctx.Err(); e != nil {
+		return fmt.Errorf("%s - write canceled: %w", file, e)
+	}
+
+	return nil
+}
+
+// checkCanceled checks for cancellation every cancelCheckInterval
+// entities, n is the number of entities written so far
+func (writer *Writer) checkCanceled(file string, n int) error {
+	if n%cancelCheckInterval != 0 {
+		return nil
+	}
+	return writer.canceled(file)
+}
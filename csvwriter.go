@@ -6,9 +6,15 @@
 package gtfswriter
 
 import (
+	"bufio"
 	"encoding/csv"
+	"fmt"
 	"io"
 	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 // Lines describes a slice of slice-encoded CSV lines
@@ -16,41 +22,213 @@ type Lines [][]string
 
 // SortedLines is a Lines object extended by information
 // on the sorting depth (1 = sort by first column, 2 =
-// sort by first and second column, and so on)
+// sort by first and second column, and so on). Lines
+// equal in the first SortDepth columns are ordered by
+// their remaining columns. If Natural is set, runs of
+// digits are compared by their numeric value, so that
+// "9" is ordered before "10".
 type SortedLines struct {
 	Lines     Lines
 	SortDepth int
+	Natural   bool
 }
 
 func (l SortedLines) Len() int      { return len(l.Lines) }
 func (l SortedLines) Swap(i, j int) { l.Lines[i], l.Lines[j] = l.Lines[j], l.Lines[i] }
 func (l SortedLines) Less(i, j int) bool {
 	for a := 0; a < l.SortDepth && a < len(l.Lines[i]); a++ {
-		if l.Lines[i][a] < l.Lines[j][a] {
-			return true
-		} else if l.Lines[i][a] != l.Lines[j][a] {
-			return false
+		if c := collate(l.Lines[i][a], l.Lines[j][a], l.Natural); c != 0 {
+			return c < 0
+		}
+	}
+
+	// tie-breaker on the full line, for reproducible output
+	for a := l.SortDepth; a < len(l.Lines[i]) && a < len(l.Lines[j]); a++ {
+		if c := collate(l.Lines[i][a], l.Lines[j][a], l.Natural); c != 0 {
+			return c < 0
 		}
 	}
 	return false
 }
 
-// A CsvWriter is a wrapper around csv.Writer
+// A SortKey is a column lines are sorted by, either lexicographically
+// or, if Numeric is set, by the numeric value of the column
+type SortKey struct {
+	Column  string
+	Numeric bool
+}
+
+// keyedLines are lines sorted by the columns at cols, numeric marks
+// the columns compared numerically. Lines equal in these columns are
+// ordered by their full line.
+type keyedLines struct {
+	lines   Lines
+	cols    []int
+	numeric []bool
+	natural bool
+}
+
+func (l keyedLines) Len() int      { return len(l.lines) }
+func (l keyedLines) Swap(i, j int) { l.lines[i], l.lines[j] = l.lines[j], l.lines[i] }
+func (l keyedLines) Less(i, j int) bool {
+	for k, col := range l.cols {
+		if c := compareValues(l.lines[i][col], l.lines[j][col], l.numeric[k], l.natural); c != 0 {
+			return c < 0
+		}
+	}
+
+	return SortedLines{l.lines, 0, l.natural}.Less(i, j)
+}
+
+// compareValues compares two values, numerically if numeric is set
+// and both are numbers. Empty values and values which are not numbers
+// are ordered before numbers, and collated among themselves.
+func compareValues(a string, b string, numeric bool, natural bool) int {
+	if numeric {
+		fa, ea := strconv.ParseFloat(a, 64)
+		fb, eb := strconv.ParseFloat(b, 64)
+		if ea == nil && eb == nil {
+			if fa < fb {
+				return -1
+			} else if fa > fb {
+				return 1
+			}
+			return strings.Compare(a, b)
+		}
+		if ea == nil {
+			return 1
+		}
+		if eb == nil {
+			return -1
+		}
+	}
+
+	return collate(a, b, natural)
+}
+
+// collate compares two values lexicographically, or in natural order
+// if natural is set
+func collate(a string, b string, natural bool) int {
+	if natural {
+		return compareNatural(a, b)
+	}
+	return strings.Compare(a, b)
+}
+
+// compareNatural compares two values in natural order: runs of digits
+// are compared by their numeric value, everything else byte-wise.
+// Values equal in natural order, like "01" and "1", are ordered
+// lexicographically.
+func compareNatural(a string, b string) int {
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if isDigit(a[i]) && isDigit(b[j]) {
+			ei, ej := i, j
+			for ei < len(a) && isDigit(a[ei]) {
+				ei++
+			}
+			for ej < len(b) && isDigit(b[ej]) {
+				ej++
+			}
+
+			// without leading zeros, a longer run is a larger number
+			na := strings.TrimLeft(a[i:ei], "0")
+			nb := strings.TrimLeft(b[j:ej], "0")
+			if len(na) != len(nb) {
+				if len(na) < len(nb) {
+					return -1
+				}
+				return 1
+			}
+			if c := strings.Compare(na, nb); c != 0 {
+				return c
+			}
+
+			i, j = ei, ej
+			continue
+		}
+
+		if a[i] != b[j] {
+			if a[i] < b[j] {
+				return -1
+			}
+			return 1
+		}
+		i++
+		j++
+	}
+
+	if i == len(a) && j < len(b) {
+		return -1
+	}
+	if j == len(b) && i < len(a) {
+		return 1
+	}
+
+	return strings.Compare(a, b)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// A QuotePolicy defines which fields are quoted
+type QuotePolicy int
+
+const (
+	// QuoteMinimal only quotes fields which contain delimiters,
+	// quotes or line breaks, or start with a space
+	QuoteMinimal QuotePolicy = iota
+	// QuoteAll quotes all fields
+	QuoteAll
+	// QuoteNonNumeric quotes all fields which are neither empty
+	// nor a decimal number
+	QuoteNonNumeric
+)
+
+// A CsvWriter is a wrapper around csv.Writer which writes GTFS files
+// with a fixed set of columns.
+//
+// Columns are defined with SetHeader. Columns which are not listed as
+// required are pruned: they are only written if at least one line has
+// a non-empty value in them. By default, columns are written in the
+// order given to SetHeader, SetOrder can be used to override this.
+//
+// Lines can either be buffered with WriteCsvLine and written on Flush,
+// which allows sorting them with SortByCols, or be written directly
+// with WriteCsvLineRaw after WriteHeader. In the latter case, the column
+// usage must be known before the header is written, so HeaderUsage has
+// to be called for all lines first.
+//
+// Errors are sticky: after the first error, all further writes are
+// skipped and return that error.
 type CsvWriter struct {
 	writer           *csv.Writer
+	out              *bufio.Writer
+	quote            QuotePolicy
+	keepAll          bool
+	natural          bool
+	hook             func(header []string, row []string) []string
+	headerWritten    bool
 	headers          []string
 	headersMap       map[string]int
 	headerUsage      []bool
 	headerUsageCount int
 	lines            Lines
 	order            map[string]int
+	stats            *FileStats
+	err              error
 }
 
 // NewCsvWriter returns a new CsvWriter instance
 func NewCsvWriter(file io.Writer) CsvWriter {
-	writer := csv.NewWriter(file)
+	// the csv.Writer shares the buffer, so that lines quoted
+	// by the CsvWriter itself can be written in between
+	out := bufio.NewWriter(file)
+	writer := csv.NewWriter(out)
 	p := CsvWriter{
 		writer:           writer,
+		out:              out,
 		headers:          make([]string, 0),
 		headersMap:       make(map[string]int, 0),
 		headerUsage:      make([]bool, 0),
@@ -59,15 +237,55 @@ func NewCsvWriter(file io.Writer) CsvWriter {
 		order:            make(map[string]int, 0),
 	}
 
+	if sw, ok := file.(*statsWriter); ok {
+		p.stats = sw.stats
+	}
+
 	return p
 }
 
-// SetHeader sets the header for this CSV file
+// SetComma sets the field delimiter, which defaults to ','
+func (p *CsvWriter) SetComma(r rune) {
+	p.writer.Comma = r
+}
+
+// SetUseCRLF sets whether lines are terminated by \r\n instead of \n
+func (p *CsvWriter) SetUseCRLF(b bool) {
+	p.writer.UseCRLF = b
+}
+
+// SetQuote sets which fields are quoted, QuoteMinimal by default
+func (p *CsvWriter) SetQuote(q QuotePolicy) {
+	p.quote = q
+}
+
+// SetKeepAll sets whether all columns are written, even if they are
+// unused. It must be called before SetHeader.
+func (p *CsvWriter) SetKeepAll(b bool) {
+	p.keepAll = b
+}
+
+// SetNaturalSort sets whether SortByCols and SortByKeys compare runs
+// of digits by their numeric value
+func (p *CsvWriter) SetNaturalSort(b bool) {
+	p.natural = b
+}
+
+// SetRowHook sets a function which is applied to each line before it
+// is buffered or written, with the full header. It returns the line to
+// be written instead, which must have one value per header column.
+func (p *CsvWriter) SetRowHook(hook func(header []string, row []string) []string) {
+	p.hook = hook
+}
+
+// SetHeader sets the header for this CSV file. Columns listed in
+// required are always written, all others only if they are used.
 func (p *CsvWriter) SetHeader(val []string, required []string) {
 	p.headerUsage = make([]bool, len(val))
 	p.headers = val
 	for i, h := range val {
 		p.headersMap[h] = i
+		p.headerUsage[i] = p.keepAll
 	}
 
 	for _, req := range required {
@@ -79,6 +297,9 @@ func (p *CsvWriter) SetHeader(val []string, required []string) {
 	}
 }
 
+// SetOrder sets the order in which columns are written. Columns in
+// order are always written, in the given order, even if unused. Other
+// used columns are appended. Names not part of the header are ignored.
 func (p *CsvWriter) SetOrder(order []string) {
 	a := 0
 	for _, name := range order {
@@ -90,74 +311,319 @@ func (p *CsvWriter) SetOrder(order []string) {
 	}
 }
 
-// WriteCsvLine writes a single slice of values to the CSV file
-func (p *CsvWriter) WriteCsvLine(val []string) {
+// WriteCsvLine buffers a single slice of values, which must have one
+// value per header column
+func (p *CsvWriter) WriteCsvLine(val []string) error {
+	if p.err != nil {
+		return p.err
+	}
+
+	if val = p.hooked(val); p.err != nil {
+		return p.err
+	}
+
+	return p.bufferLine(val)
+}
+
+// bufferLine buffers a single line
+func (p *CsvWriter) bufferLine(val []string) error {
+	if len(val) != len(p.headers) {
+		p.err = fmt.Errorf("line has %d values, but the header has %d columns", len(val), len(p.headers))
+		return p.err
+	}
+
 	p.lines = append(p.lines, val)
 
 	p.HeaderUsage(val)
+
+	return nil
+}
+
+// WriteCsvLineRaw writes a single slice of values to the CSV file,
+// without buffering. The header must have been written before.
+func (p *CsvWriter) WriteCsvLineRaw(val []string) error {
+	if p.err != nil {
+		return p.err
+	}
+
+	if val = p.hooked(val); p.err != nil {
+		return p.err
+	}
+
+	return p.writeLine(val)
 }
 
-// WriteCsvLineRaw writes a single slice of values to the CSV file
-func (p *CsvWriter) WriteCsvLineRaw(val []string) {
+// writeHooked buffers or writes a single line the row hook was already
+// applied to
+func (p *CsvWriter) writeHooked(val []string, buffered bool) error {
+	if p.err != nil {
+		return p.err
+	}
+
+	if buffered {
+		return p.bufferLine(val)
+	}
+
+	return p.writeLine(val)
+}
+
+// hasHook checks whether a row hook is set
+func (p *CsvWriter) hasHook() bool {
+	return p.hook != nil
+}
+
+// hooked returns a line as changed by the row hook
+func (p *CsvWriter) hooked(val []string) []string {
+	if p.hook == nil {
+		return val
+	}
+
+	ret := p.hook(p.headers, val)
+	if len(ret) != len(p.headers) {
+		p.err = fmt.Errorf("row hook returned %d values, but the header has %d columns", len(ret), len(p.headers))
+		return val
+	}
+
+	return ret
+}
+
+// writeLine writes a single line to the CSV file
+func (p *CsvWriter) writeLine(val []string) error {
 	p.maskLine(&val)
-	e := p.writer.Write(val)
 
-	if e != nil {
-		panic(e)
+	if p.err = p.write(val); p.err != nil {
+		return p.err
+	}
+
+	if p.stats != nil {
+		p.stats.Rows++
 	}
+
+	return nil
 }
 
-// HeaderUsage updates the header usage for a single row
+// HeaderUsage marks the columns in which a line has non-empty values
+// as used
 func (p *CsvWriter) HeaderUsage(val []string) {
 	for i, v := range val {
-		if len(v) > 0 {
+		if len(v) > 0 && i < len(p.headerUsage) {
 			p.headerUsage[i] = true
 		}
 	}
 }
 
-// SortByCols sorts the current line cache by depth
+// SortByCols sorts the buffered lines by their first depth columns,
+// and lines equal in these columns by their remaining columns
 func (p *CsvWriter) SortByCols(depth int) {
-	sort.Sort(SortedLines{p.lines, depth})
+	sort.Stable(SortedLines{p.lines, depth, p.natural})
+}
+
+// SortByKeys sorts the buffered lines by the given columns, and lines
+// equal in these columns by their full line. Unknown columns are an
+// error.
+func (p *CsvWriter) SortByKeys(keys []SortKey) error {
+	if p.err != nil {
+		return p.err
+	}
+
+	l := keyedLines{p.lines, make([]int, len(keys)), make([]bool, len(keys)), p.natural}
+	for i, k := range keys {
+		col, ok := p.headersMap[k.Column]
+		if !ok {
+			p.err = fmt.Errorf("unknown sort column '%s'", k.Column)
+			return p.err
+		}
+		l.cols[i] = col
+		l.numeric[i] = k.Numeric
+	}
+
+	sort.Stable(l)
+
+	return nil
 }
 
-// Flush the current line cache into the CSV file
-func (p *CsvWriter) Flush() {
+// Flush writes the header and all buffered lines to the CSV file. If
+// no lines were buffered, the full header is written.
+func (p *CsvWriter) Flush() error {
+	if p.err != nil {
+		return p.err
+	}
+
 	if len(p.lines) == 0 {
-		e := p.writer.Write(p.headers)
-		p.writer.Flush()
-		if e != nil {
-			panic(e)
+		if p.stats != nil {
+			p.stats.Columns = p.headers
 		}
-		return
+		if p.err = p.write(p.headers); p.err != nil {
+			return p.err
+		}
+		return p.FlushFile()
 	}
 
-	p.WriteHeader()
+	if e := p.WriteHeader(); e != nil {
+		return e
+	}
 
 	for _, v := range p.lines {
-		p.WriteCsvLineRaw(v)
+		if e := p.writeLine(v); e != nil {
+			return e
+		}
 	}
-	p.FlushFile()
 	p.lines = nil
+
+	return p.FlushFile()
+}
+
+// pruned returns the columns of the header which are not in written
+func (p *CsvWriter) pruned(written []string) []string {
+	in := make(map[string]bool, len(written))
+	for _, name := range written {
+		in[name] = true
+	}
+
+	ret := make([]string, 0)
+	for _, name := range p.headers {
+		if !in[name] {
+			ret = append(ret, name)
+		}
+	}
+
+	return ret
 }
 
-func (p *CsvWriter) WriteHeader() {
+// WriteHeader writes the header, without unused columns
+func (p *CsvWriter) WriteHeader() error {
+	if p.err != nil {
+		return p.err
+	}
+
 	// mask header
 	headerCp := append([]string(nil), p.headers...)
 	p.maskLine(&headerCp)
 
+	if p.stats != nil {
+		p.stats.Columns = headerCp
+		p.stats.PrunedColumns = p.pruned(headerCp)
+	}
+
+	p.headerWritten = true
+
 	// write header
-	e := p.writer.Write(headerCp)
+	p.err = p.write(headerCp)
+
+	return p.err
+}
+
+// FlushFile flushes the lines written so far into the underlying writer
+func (p *CsvWriter) FlushFile() error {
+	p.writer.Flush()
 
-	if e != nil {
-		panic(e)
+	if p.err == nil {
+		p.err = p.writer.Error()
 	}
 
+	return p.err
 }
 
-// Flush the current line cache into the CSV file
-func (p *CsvWriter) FlushFile() {
-	p.writer.Flush()
+// Error returns the first error which occurred during writing, if any
+func (p *CsvWriter) Error() error {
+	return p.err
+}
+
+// write writes a single line with the quote policy of the writer
+func (p *CsvWriter) write(record []string) error {
+	if p.quote == QuoteMinimal {
+		return p.writer.Write(record)
+	}
+
+	for i, field := range record {
+		if i > 0 {
+			p.out.WriteRune(p.writer.Comma)
+		}
+
+		if !p.quoted(field) {
+			p.out.WriteString(field)
+			continue
+		}
+
+		p.out.WriteByte('"')
+		for _, r := range field {
+			switch r {
+			case '"':
+				p.out.WriteString(`""`)
+			case '\r':
+				if !p.writer.UseCRLF {
+					p.out.WriteByte('\r')
+				}
+			case '\n':
+				if p.writer.UseCRLF {
+					p.out.WriteString("\r\n")
+				} else {
+					p.out.WriteByte('\n')
+				}
+			default:
+				p.out.WriteRune(r)
+			}
+		}
+		p.out.WriteByte('"')
+	}
+
+	var e error
+	if p.writer.UseCRLF {
+		_, e = p.out.WriteString("\r\n")
+	} else {
+		e = p.out.WriteByte('\n')
+	}
+
+	return e
+}
+
+// quoted checks whether a field is quoted under the quote policy of
+// the writer, fields which need quotes are always quoted
+func (p *CsvWriter) quoted(field string) bool {
+	if p.quote == QuoteAll || p.needsQuotes(field) {
+		return true
+	}
+
+	return len(field) > 0 && !isDecimal(field)
+}
+
+// needsQuotes checks whether a field must be quoted, like csv.Writer
+// does
+func (p *CsvWriter) needsQuotes(field string) bool {
+	if field == "" {
+		return false
+	}
+
+	if field == `\.` {
+		return true
+	}
+
+	if strings.ContainsRune(field, p.writer.Comma) || strings.ContainsAny(field, "\"\r\n") {
+		return true
+	}
+
+	r, _ := utf8.DecodeRuneInString(field)
+	return unicode.IsSpace(r)
+}
+
+// isDecimal checks whether a field is a decimal number like -1 or 0.5
+func isDecimal(field string) bool {
+	if field[0] == '-' {
+		field = field[1:]
+	}
+
+	digits, dot := 0, false
+	for i := 0; i < len(field); i++ {
+		if field[i] == '.' && !dot {
+			dot = true
+		} else if field[i] >= '0' && field[i] <= '9' {
+			digits++
+		} else {
+			return false
+		}
+	}
+
+	return digits > 0
 }
 
 func (p *CsvWriter) maskLine(val *[]string) {
@@ -0,0 +1,83 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// A DistUnit is the unit of shape_dist_traveled values
+type DistUnit int
+
+const (
+	// DistUnitAsIs leaves distances unconverted
+	DistUnitAsIs DistUnit = iota
+	// Meters measures distances in meters
+	Meters
+	// Kilometers measures distances in kilometers
+	Kilometers
+	// Feet measures distances in feet
+	Feet
+	// Miles measures distances in statute miles
+	Miles
+)
+
+// meters returns the length of the unit in meters
+func (u DistUnit) meters() float64 {
+	switch u {
+	case Kilometers:
+		return 1000
+	case Feet:
+		return 0.3048
+	case Miles:
+		return 1609.344
+	}
+	return 1
+}
+
+// dist converts a shape_dist_traveled value from SourceDistUnit to
+// TargetDistUnit
+func (o RowOptions) dist(d float32) float32 {
+	if o.SourceDistUnit == DistUnitAsIs || o.TargetDistUnit == DistUnitAsIs || o.SourceDistUnit == o.TargetDistUnit {
+		return d
+	}
+	return float32(float64(d) * o.SourceDistUnit.meters() / o.TargetDistUnit.meters())
+}
+
+// formatDist converts and formats a shape_dist_traveled value, rounded
+// to DistDecimals decimals. Trailing zeros are dropped.
+func (o RowOptions) formatDist(d float32) string {
+	if o.DistDecimals == 0 {
+		return formatFloat(o.dist(d))
+	}
+
+	decimals := o.DistDecimals
+	if decimals < 0 {
+		decimals = 0
+	}
+
+	var arr [64]byte
+	buf := strconv.AppendFloat(arr[:0], float64(o.dist(d)), 'f', decimals, 64)
+
+	if decimals > 0 {
+		buf = bytes.TrimRight(buf, "0")
+		buf = bytes.TrimSuffix(buf, []byte("."))
+	}
+
+	if string(buf) == "-0" {
+		return "0"
+	}
+
+	return string(buf)
+}
+
+// formatDist converts and formats a shape_dist_traveled value with the
+// settings of the writer
+func (writer *Writer) formatDist(d float32) string {
+	return writer.rowOptions().formatDist(d)
+}
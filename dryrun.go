@@ -0,0 +1,57 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"io"
+	"io/fs"
+	opath "path"
+	"time"
+)
+
+// discardBackend discards all output of a dry run. Every path is
+// an existing, empty directory.
+type discardBackend struct{}
+
+func (discardBackend) Create(name string) (io.WriteCloser, error) {
+	return discardFile{}, nil
+}
+
+func (discardBackend) Remove(name string) error {
+	return nil
+}
+
+func (discardBackend) Stat(name string) (fs.FileInfo, error) {
+	return dirInfo(opath.Base(name)), nil
+}
+
+type discardFile struct{}
+
+func (discardFile) Write(p []byte) (int, error) {
+	return io.Discard.Write(p)
+}
+
+func (discardFile) Close() error {
+	return nil
+}
+
+// dirInfo is the fs.FileInfo of a directory of a backend which has
+// no real directories
+type dirInfo string
+
+func (d dirInfo) Name() string       { return string(d) }
+func (d dirInfo) Size() int64        { return 0 }
+func (d dirInfo) Mode() fs.FileMode  { return fs.ModeDir | 0755 }
+func (d dirInfo) ModTime() time.Time { return time.Time{} }
+func (d dirInfo) IsDir() bool        { return true }
+func (d dirInfo) Sys() interface{}   { return nil }
+
+// local checks whether the output is written to the local file system
+func (writer *Writer) local() bool {
+	_, ok := writer.backend().(osBackend)
+	return ok && writer.extZip == nil
+}
@@ -0,0 +1,119 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"fmt"
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// checkDuplicates checks the written keys of transfers and attributions
+// for uniqueness, according to the DuplicateCheck policy. Primary
+// entities are keyed by their ID in the feed and cannot be duplicated.
+//
+// Transfers which become duplicates because of merged stops or routes
+// are always written once. If DropDuplicates is set, identical
+// attributions are written once, and attributions sharing the ID of
+// a different attribution get a new, unique ID.
+func (writer *Writer) checkDuplicates(feed *gtfsparser.Feed, attrs entAttrs) error {
+	writer.dupAttrs = make(map[EntAttr]bool)
+	writer.attrIds = make(map[EntAttr]string)
+
+	if writer.DuplicateCheck == CheckOff && !writer.DropDuplicates {
+		return nil
+	}
+
+	// transfers
+	count := make(map[gtfs.TransferKey]int)
+	for tk := range feed.Transfers {
+		if !writer.excl.transfer(tk) {
+			count[writer.transferKey(tk)]++
+		}
+	}
+
+	for tk, n := range count {
+		if n > 1 {
+			writer.Report.Duplicates["transfers.txt"] = append(writer.Report.Duplicates["transfers.txt"], transferId(tk))
+		}
+	}
+
+	// attributions
+	used := make(map[string]bool, len(attrs))
+	for _, ea := range attrs {
+		used[ea.attr.Id] = true
+	}
+
+	first := make(map[string][]string, len(attrs))
+	dup := make(map[string]bool)
+
+	for _, ea := range attrs {
+		row := writer.attributionRow(ea)
+		id := row[0]
+
+		if len(id) == 0 {
+			continue
+		}
+
+		prev, ok := first[id]
+		if !ok {
+			first[id] = row
+			continue
+		}
+
+		if !dup[id] {
+			dup[id] = true
+			writer.Report.Duplicates["attributions.txt"] = append(writer.Report.Duplicates["attributions.txt"], id)
+		}
+
+		if !writer.DropDuplicates {
+			continue
+		}
+
+		if strings.Join(prev, "\x00") == strings.Join(row, "\x00") {
+			writer.dupAttrs[ea] = true
+			writer.change("attributions.txt", id, "", ChangeDropped, "", "")
+			continue
+		}
+
+		newId := id + "_2"
+		for i := 3; used[newId]; i++ {
+			newId = id + "_" + strconv.Itoa(i)
+		}
+		used[newId] = true
+		writer.attrIds[ea] = newId
+		writer.change("attributions.txt", id, "attribution_id", ChangeModified, id, newId)
+	}
+
+	files := make([]string, 0, len(writer.Report.Duplicates))
+	for file, ids := range writer.Report.Duplicates {
+		sort.Strings(ids)
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	if writer.DuplicateCheck == CheckWarn {
+		for _, file := range files {
+			for _, id := range writer.Report.Duplicates[file] {
+				writer.warn(file, id, "", "duplicate key")
+			}
+		}
+	}
+
+	if writer.DuplicateCheck == CheckFail && len(files) > 0 {
+		list := make([]string, len(files))
+		for i, file := range files {
+			list[i] = fmt.Sprintf("%s (%s)", file, strings.Join(writer.Report.Duplicates[file], ", "))
+		}
+		return writeError{files[0], "duplicate keys: " + strings.Join(list, "; ")}
+	}
+
+	return nil
+}
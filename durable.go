@@ -0,0 +1,44 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// syncDir flushes the directory entries of the output to stable
+// storage, so that the written files survive a power loss
+func (writer *Writer) syncDir(path string) error {
+	// directories cannot be synced on Windows
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	fileInfo, e := os.Stat(path)
+	if e != nil {
+		return writeError{path, e.Error()}
+	}
+
+	dir := path
+	if !fileInfo.IsDir() {
+		dir = filepath.Dir(path)
+	}
+
+	d, e := os.Open(dir)
+	if e != nil {
+		return writeError{dir, e.Error()}
+	}
+	defer d.Close()
+
+	if e := d.Sync(); e != nil {
+		return writeError{dir, e.Error()}
+	}
+
+	return nil
+}
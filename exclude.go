@@ -0,0 +1,81 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+)
+
+// exclusions holds the entities which are dropped from the output
+// of the current write
+type exclusions struct {
+	stops  map[*gtfs.Stop]bool
+	levels map[*gtfs.Level]bool
+}
+
+func newExclusions() exclusions {
+	return exclusions{
+		stops:  make(map[*gtfs.Stop]bool),
+		levels: make(map[*gtfs.Level]bool),
+	}
+}
+
+// cascade extends the exclusions to all entities that depend on
+// excluded ones: stops whose parent station is excluded, and levels
+// which are only referenced by excluded stops. Pathways and transfers
+// referencing excluded stops are skipped during writing.
+func (ex *exclusions) cascade(feed *gtfsparser.Feed) {
+	if len(ex.stops) == 0 {
+		return
+	}
+
+	// exclude children of excluded stations, this may take several
+	// rounds for boarding areas of platforms of excluded stations
+	for changed := true; changed; {
+		changed = false
+		for _, s := range feed.Stops {
+			if !ex.stops[s] && s.Parent_station != nil && ex.stops[s.Parent_station] {
+				ex.stops[s] = true
+				changed = true
+			}
+		}
+	}
+
+	usedLevels := make(map[*gtfs.Level]bool)
+	for _, s := range feed.Stops {
+		if s.Level != nil && !ex.stops[s] {
+			usedLevels[s.Level] = true
+		}
+	}
+
+	for _, s := range feed.Stops {
+		if s.Level != nil && ex.stops[s] && !usedLevels[s.Level] {
+			ex.levels[s.Level] = true
+		}
+	}
+}
+
+// recordExclusions records the excluded stops and levels as changes
+func (writer *Writer) recordExclusions() {
+	for s := range writer.excl.stops {
+		writer.change("stops.txt", s.Id, "", ChangeDropped, "", "")
+	}
+	for l := range writer.excl.levels {
+		writer.change("levels.txt", l.Id, "", ChangeDropped, "", "")
+	}
+}
+
+// pathway checks whether a pathway references an excluded stop
+func (ex *exclusions) pathway(p *gtfs.Pathway) bool {
+	return ex.stops[p.From_stop] || ex.stops[p.To_stop]
+}
+
+// transfer checks whether a transfer references an excluded stop
+func (ex *exclusions) transfer(tk gtfs.TransferKey) bool {
+	return (tk.From_stop != nil && ex.stops[tk.From_stop]) || (tk.To_stop != nil && ex.stops[tk.To_stop])
+}
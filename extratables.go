@@ -0,0 +1,95 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"errors"
+	"github.com/patrickbr/gtfsparser"
+	"strings"
+)
+
+// an extraTable is an additional file registered with
+// RegisterExtraTable, and the function writing it
+type extraTable struct {
+	name string
+	fn   func(*gtfsparser.Feed, *CsvWriter) error
+}
+
+// RegisterExtraTable registers an additional file, e.g. a vendor
+// specific vehicle_categories.txt, which is written after the GTFS
+// files into the same directory or ZIP file. fn sets the header of
+// the file and writes its lines, either buffered with WriteCsvLine or
+// directly with WriteCsvLineRaw after WriteHeader. The file is flushed
+// by the writer. Registering a name again replaces its function.
+func (writer *Writer) RegisterExtraTable(name string, fn func(*gtfsparser.Feed, *CsvWriter) error) {
+	for i, t := range writer.extraTables {
+		if t.name == name {
+			writer.extraTables[i].fn = fn
+			return
+		}
+	}
+
+	writer.extraTables = append(writer.extraTables, extraTable{name, fn})
+}
+
+// checkExtraTables checks that the registered files have plain file
+// names which do not collide with the GTFS files
+func (writer *Writer) checkExtraTables(tables []table) error {
+	gtfsFiles := make(map[string]bool, len(tables))
+	for _, t := range tables {
+		gtfsFiles[t.name] = true
+	}
+
+	for _, t := range writer.extraTables {
+		if len(t.name) == 0 || t.name == "." || t.name == ".." || strings.ContainsAny(t.name, "/\\") {
+			return writeError{t.name, "invalid extra table name"}
+		}
+
+		if gtfsFiles[t.name] {
+			return writeError{t.name, "extra table would replace a GTFS file"}
+		}
+	}
+
+	return nil
+}
+
+// writeExtraTable returns the write function of a registered file
+func (writer *Writer) writeExtraTable(t extraTable) func(path string, feed *gtfsparser.Feed) error {
+	return func(path string, feed *gtfsparser.Feed) (err error) {
+		file, e := writer.getFileForWriting(path, t.name)
+
+		if e != nil {
+			return errors.New("Could not open required file " + t.name + " for writing")
+		}
+
+		defer func() {
+			if r := recover(); r != nil {
+				err = writer.recovered(t.name, r)
+			}
+		}()
+
+		csvwriter := writer.newCsvWriter(file, t.name)
+
+		if e := t.fn(feed, &csvwriter); e != nil {
+			return writeError{t.name, e.Error()}
+		}
+
+		if csvwriter.headerWritten {
+			if fe := csvwriter.FlushFile(); fe != nil {
+				return writeError{t.name, fe.Error()}
+			}
+			return nil
+		}
+
+		writer.sortLines(&csvwriter, t.name, 1)
+		if fe := csvwriter.Flush(); fe != nil {
+			return writeError{t.name, fe.Error()}
+		}
+
+		return nil
+	}
+}
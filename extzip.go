@@ -0,0 +1,78 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/klauspost/compress/zip"
+	"github.com/patrickbr/gtfsparser"
+	"hash"
+	"io"
+)
+
+// WriteToZip writes a feed into zw, a ZIP file the caller is building,
+// e.g. to bundle several feeds into a single archive. The names of all
+// written files are prefixed by prefix, e.g. "feeds/de/". zw is not
+// closed, and ZipCompressionLevel and ZipComment are not applied to
+// it. If Compression is CompressZstd, the Zstandard compressor is
+// registered for zw.
+func (writer *Writer) WriteToZip(feed *gtfsparser.Feed, zw *zip.Writer, prefix string) error {
+	if writer.Compression == CompressZstd {
+		registerZstd(zw, writer.ZipCompressionLevel)
+	}
+
+	writer.extZip = zw
+	writer.zipPrefix = prefix
+
+	defer func() {
+		writer.extZip = nil
+		writer.zipPrefix = ""
+	}()
+
+	return writer.Write(feed, "")
+}
+
+// WriteToStream writes a feed as a ZIP file to out, without seeking
+// and without temporary files, e.g. into the pipe of a multipart
+// upload to object storage. All settings for ZIP files apply.
+func (writer *Writer) WriteToStream(feed *gtfsparser.Feed, out io.Writer) error {
+	var sum hash.Hash
+	if writer.checksums() {
+		sum = sha256.New()
+		out = io.MultiWriter(out, sum)
+	}
+
+	zw, e := writer.newZipWriter(writer.withThrottle(writer.withFileTimeout(out)))
+	if e != nil {
+		return e
+	}
+
+	writer.extZip = zw
+	writer.stream = true
+
+	defer func() {
+		writer.extZip = nil
+		writer.stream = false
+	}()
+
+	e = writer.Write(feed, "")
+
+	// finishing the ZIP file gets its own deadline
+	writer.startDeadline()
+
+	if ce := zw.Close(); e == nil {
+		e = ce
+	}
+
+	if e == nil && sum != nil {
+		writer.Report.ArchiveSha256 = hex.EncodeToString(sum.Sum(nil))
+		e = writer.writeChecksumFile("-")
+	}
+
+	return e
+}
@@ -0,0 +1,129 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"github.com/klauspost/compress/zip"
+	"hash"
+	"io"
+	"time"
+)
+
+// FileStats holds timing and throughput information for a single
+// file written by the writer
+type FileStats struct {
+	// name of the file
+	Name string
+
+	// number of rows written, without the header
+	Rows int
+
+	// number of uncompressed bytes written
+	Bytes int64
+
+	// columns written, in order
+	Columns []string
+
+	// optional columns not written because they are unused
+	PrunedColumns []string
+
+	// size of the compressed ZIP member, 0 if not written to a ZIP file
+	CompressedBytes int64
+
+	// time between opening the file and the last write
+	Duration time.Duration
+
+	// time spent in writes to the output, including compression
+	WriteDuration time.Duration
+
+	// hex-encoded SHA-256 of the uncompressed file, if Checksums is set
+	Sha256 string
+
+	// whether the file was not rewritten as its content is unchanged,
+	// if SkipUnchanged is set
+	Unchanged bool
+
+	sum hash.Hash
+}
+
+// RowsPerSec returns the number of rows written per second
+func (fs FileStats) RowsPerSec() float64 {
+	if fs.Duration <= 0 {
+		return 0
+	}
+	return float64(fs.Rows) / fs.Duration.Seconds()
+}
+
+// BytesPerSec returns the number of uncompressed bytes written per second
+func (fs FileStats) BytesPerSec() float64 {
+	if fs.Duration <= 0 {
+		return 0
+	}
+	return float64(fs.Bytes) / fs.Duration.Seconds()
+}
+
+// Ratio returns the compressed size of the file relative to its
+// uncompressed size, 0 if the file was not compressed
+func (fs FileStats) Ratio() float64 {
+	if fs.Bytes == 0 {
+		return 0
+	}
+	return float64(fs.CompressedBytes) / float64(fs.Bytes)
+}
+
+// compressionStats reads the compressed sizes of all written files
+// from the finished ZIP file
+func (writer *Writer) compressionStats(path string) error {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	sizes := make(map[string]int64, len(r.File))
+	for _, f := range r.File {
+		sizes[f.Name] = int64(f.CompressedSize64)
+	}
+
+	for _, fs := range writer.Report.Files {
+		fs.CompressedBytes = sizes[fs.Name]
+	}
+
+	return nil
+}
+
+// statsWriter wraps the output of a single file and
+// collects its FileStats
+type statsWriter struct {
+	w       io.Writer
+	start   time.Time
+	stats   *FileStats
+	sinkErr *error
+}
+
+func newStatsWriter(w io.Writer, stats *FileStats, sinkErr *error) *statsWriter {
+	return &statsWriter{w, time.Now(), stats, sinkErr}
+}
+
+func (sw *statsWriter) Write(p []byte) (int, error) {
+	t := time.Now()
+	n, err := sw.w.Write(p)
+	now := time.Now()
+
+	sw.stats.Bytes += int64(n)
+	if sw.stats.sum != nil {
+		sw.stats.sum.Write(p[:n])
+	}
+	sw.stats.WriteDuration += now.Sub(t)
+	sw.stats.Duration = now.Sub(sw.start)
+
+	if err != nil && sw.sinkErr != nil {
+		*sw.sinkErr = err
+	}
+
+	return n, err
+}
@@ -0,0 +1,487 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"math"
+	"strconv"
+)
+
+// A Filter selects the part of a feed which is written. Empty lists
+// and dates select all entities.
+type Filter struct {
+	// agencies whose routes are written
+	AgencyIds []string
+
+	// routes which are written
+	RouteIds []string
+
+	// first and last day of the window in which written trips must
+	// be active, calendars are clamped to it
+	StartDate gtfs.Date
+	EndDate   gtfs.Date
+
+	// area outside of which stops are dropped
+	Box *BoundingBox
+}
+
+// A BoundingBox is a geographic area given by its corner coordinates
+type BoundingBox struct {
+	MinLat float32
+	MinLon float32
+	MaxLat float32
+	MaxLon float32
+}
+
+// contains checks whether a coordinate lies within the box
+func (b *BoundingBox) contains(lat float32, lon float32) bool {
+	return lat >= b.MinLat && lat <= b.MaxLat && lon >= b.MinLon && lon <= b.MaxLon
+}
+
+// containsStop checks whether a stop lies within the box, stops without
+// coordinates are located by their parent station
+func (b *BoundingBox) containsStop(s *gtfs.Stop) bool {
+	for ; s != nil; s = s.Parent_station {
+		if !math.IsNaN(float64(s.Lat)) && !math.IsNaN(float64(s.Lon)) {
+			return b.contains(s.Lat, s.Lon)
+		}
+	}
+	return false
+}
+
+// active checks whether the filter selects a part of the feed
+func (f Filter) active() bool {
+	return len(f.AgencyIds) > 0 || len(f.RouteIds) > 0 || f.dated() || f.Box != nil
+}
+
+// dated checks whether the filter has a date window
+func (f Filter) dated() bool {
+	return !f.StartDate.IsEmpty() || !f.EndDate.IsEmpty()
+}
+
+// inWindow checks whether a day lies within the date window
+func (f Filter) inWindow(d gtfs.Date) bool {
+	return (f.StartDate.IsEmpty() || !d.GetTime().Before(f.StartDate.GetTime())) &&
+		(f.EndDate.IsEmpty() || !d.GetTime().After(f.EndDate.GetTime()))
+}
+
+// activeInWindow checks whether a service is active on any day of the
+// date window
+func (f Filter) activeInWindow(s *gtfs.Service) bool {
+	first, last := s.GetFirstDefinedDate(), s.GetLastDefinedDate()
+	if first.IsEmpty() || last.IsEmpty() {
+		return false
+	}
+
+	if !f.StartDate.IsEmpty() && first.GetTime().Before(f.StartDate.GetTime()) {
+		first = f.StartDate
+	}
+	if !f.EndDate.IsEmpty() && last.GetTime().After(f.EndDate.GetTime()) {
+		last = f.EndDate
+	}
+
+	for d := first; !d.GetTime().After(last.GetTime()); d = d.GetOffsettedDate(1) {
+		if s.IsActiveOn(d) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// clamp returns a service limited to the date window, or s itself if
+// it lies within the window
+func (f Filter) clamp(s *gtfs.Service) *gtfs.Service {
+	start, end := s.Start_date(), s.End_date()
+	daymap := s.RawDaymap()
+
+	if !start.IsEmpty() && !f.StartDate.IsEmpty() && start.GetTime().Before(f.StartDate.GetTime()) {
+		start = f.StartDate
+	}
+	if !end.IsEmpty() && !f.EndDate.IsEmpty() && end.GetTime().After(f.EndDate.GetTime()) {
+		end = f.EndDate
+	}
+
+	// the regular service lies outside the window
+	if !start.IsEmpty() && !end.IsEmpty() && start.GetTime().After(end.GetTime()) {
+		start, end, daymap = gtfs.Date{}, gtfs.Date{}, 0
+	}
+
+	exceptions := make(map[gtfs.Date]bool, len(s.Exceptions()))
+	for d, v := range s.Exceptions() {
+		if f.inWindow(d) {
+			exceptions[d] = v
+		}
+	}
+
+	if start == s.Start_date() && end == s.End_date() && len(exceptions) == len(s.Exceptions()) {
+		return s
+	}
+
+	return gtfs.NewService(s.Id(), daymap, start, end, exceptions)
+}
+
+// filterFeed returns a copy of feed which only holds the routes
+// selected by Filter, their trips active in the date window, and the
+// entities these depend on. The entities themselves are shared with
+// feed and not modified, only fare attributes whose rules were dropped,
+// services and feed infos clamped to the date window, and trips and
+// shapes trimmed to the bounding box are copied. Dropped, clamped and
+// trimmed entities are recorded as changes.
+func (writer *Writer) filterFeed(feed *gtfsparser.Feed) *gtfsparser.Feed {
+	if !writer.Filter.active() {
+		return feed
+	}
+
+	sub := *feed
+
+	agencyIds := idSet(writer.Filter.AgencyIds)
+	routeIds := idSet(writer.Filter.RouteIds)
+
+	// the agency of routes without one, if it is unambiguous
+	var defAgency *gtfs.Agency
+	if len(feed.Agencies) == 1 {
+		for _, a := range feed.Agencies {
+			defAgency = a
+		}
+	}
+
+	sub.Routes = make(map[string]*gtfs.Route)
+	for id, r := range feed.Routes {
+		a := r.Agency
+		if a == nil {
+			a = defAgency
+		}
+		if (len(routeIds) == 0 || routeIds[r.Id]) && (len(agencyIds) == 0 || a != nil && agencyIds[a.Id]) {
+			sub.Routes[id] = r
+		} else {
+			writer.change("routes.txt", r.Id, "", ChangeDropped, "", "")
+		}
+	}
+
+	// services active within the date window
+	active := make(map[*gtfs.Service]bool)
+	if writer.Filter.dated() {
+		for _, s := range feed.Services {
+			active[s] = writer.Filter.activeInWindow(s)
+		}
+	}
+
+	sub.Trips = make(map[string]*gtfs.Trip)
+	sub.NumStopTimes = 0
+	for id, t := range feed.Trips {
+		if t.Route != nil && sub.Routes[t.Route.Id] == t.Route && (!writer.Filter.dated() || active[t.Service]) {
+			sub.Trips[id] = t
+			sub.NumStopTimes += len(t.StopTimes)
+		} else {
+			writer.change("trips.txt", t.Id, "", ChangeDropped, "", "")
+		}
+	}
+
+	if writer.Filter.Box != nil {
+		writer.trimTrips(&sub)
+	}
+
+	writer.filterDependents(feed, &sub)
+
+	if writer.Filter.Box != nil {
+		writer.trimShapes(&sub)
+	}
+
+	if writer.Filter.dated() {
+		writer.clampDates(feed, &sub)
+	}
+
+	return &sub
+}
+
+// clampDates limits the services and feed infos of sub to the date
+// window of Filter
+func (writer *Writer) clampDates(feed *gtfsparser.Feed, sub *gtfsparser.Feed) {
+	for id, s := range sub.Services {
+		c := writer.Filter.clamp(s)
+		if c == s {
+			continue
+		}
+		sub.Services[id] = c
+		if c.Start_date() != s.Start_date() {
+			writer.change("calendar.txt", s.Id(), "start_date", ChangeModified, dateToString(s.Start_date()), dateToString(c.Start_date()))
+		}
+		if c.End_date() != s.End_date() {
+			writer.change("calendar.txt", s.Id(), "end_date", ChangeModified, dateToString(s.End_date()), dateToString(c.End_date()))
+		}
+	}
+
+	// additional fields are keyed by the feed info
+	sub.FeedInfos = make([]*gtfs.FeedInfo, 0, len(feed.FeedInfos))
+	sub.FeedInfosAddFlds = make(map[string]map[*gtfs.FeedInfo]string, len(feed.FeedInfosAddFlds))
+	for name := range feed.FeedInfosAddFlds {
+		sub.FeedInfosAddFlds[name] = make(map[*gtfs.FeedInfo]string)
+	}
+
+	for _, fi := range feed.FeedInfos {
+		c := *fi
+		if !c.Start_date.IsEmpty() && !writer.Filter.StartDate.IsEmpty() && c.Start_date.GetTime().Before(writer.Filter.StartDate.GetTime()) {
+			c.Start_date = writer.Filter.StartDate
+			writer.change("feed_info.txt", "", "feed_start_date", ChangeModified, dateToString(fi.Start_date), dateToString(c.Start_date))
+		}
+		if !c.End_date.IsEmpty() && !writer.Filter.EndDate.IsEmpty() && c.End_date.GetTime().After(writer.Filter.EndDate.GetTime()) {
+			c.End_date = writer.Filter.EndDate
+			writer.change("feed_info.txt", "", "feed_end_date", ChangeModified, dateToString(fi.End_date), dateToString(c.End_date))
+		}
+		sub.FeedInfos = append(sub.FeedInfos, &c)
+		for name, vals := range feed.FeedInfosAddFlds {
+			if v, ok := vals[fi]; ok {
+				sub.FeedInfosAddFlds[name][&c] = v
+			}
+		}
+	}
+}
+
+// trimTrips drops the stop times of the trips of sub outside of the
+// bounding box, and the trips left with less than two stop times
+func (writer *Writer) trimTrips(sub *gtfsparser.Feed) {
+	sub.NumStopTimes = 0
+	for id, t := range sub.Trips {
+		sts := make(gtfs.StopTimes, 0, len(t.StopTimes))
+		for i := range t.StopTimes {
+			if writer.Filter.Box.containsStop(t.StopTimes[i].Stop()) {
+				sts = append(sts, t.StopTimes[i])
+			}
+		}
+
+		if len(sts) < 2 {
+			delete(sub.Trips, id)
+			writer.change("trips.txt", t.Id, "", ChangeDropped, "", "")
+			continue
+		}
+
+		if len(sts) < len(t.StopTimes) {
+			for i := range t.StopTimes {
+				if !writer.Filter.Box.containsStop(t.StopTimes[i].Stop()) {
+					writer.change("stop_times.txt", t.Id, "stop_sequence", ChangeDropped, strconv.Itoa(t.StopTimes[i].Sequence()), "")
+				}
+			}
+			cp := *t
+			cp.StopTimes = sts
+			sub.Trips[id] = &cp
+		}
+
+		sub.NumStopTimes += len(sts)
+	}
+}
+
+// trimShapes drops the points of the shapes of sub outside of the
+// bounding box. Shapes left with less than two points are dropped and
+// removed from their trips, transfers refer to the copied trips.
+func (writer *Writer) trimShapes(sub *gtfsparser.Feed) {
+	trimmed := make(map[*gtfs.Shape]*gtfs.Shape)
+	sub.NumShpPoints = 0
+	for id, s := range sub.Shapes {
+		pts := make(gtfs.ShapePoints, 0, len(s.Points))
+		for _, p := range s.Points {
+			if writer.Filter.Box.contains(p.Lat, p.Lon) {
+				pts = append(pts, p)
+			}
+		}
+
+		if len(pts) == len(s.Points) {
+			sub.NumShpPoints += len(pts)
+			continue
+		}
+
+		for _, p := range s.Points {
+			if !writer.Filter.Box.contains(p.Lat, p.Lon) {
+				writer.change("shapes.txt", s.Id, "shape_pt_sequence", ChangeDropped, strconv.FormatUint(uint64(p.Sequence), 10), "")
+			}
+		}
+
+		if len(pts) < 2 {
+			delete(sub.Shapes, id)
+			trimmed[s] = nil
+			writer.change("shapes.txt", s.Id, "", ChangeDropped, "", "")
+			continue
+		}
+
+		cp := &gtfs.Shape{Id: s.Id, Points: pts}
+		sub.Shapes[id] = cp
+		trimmed[s] = cp
+		sub.NumShpPoints += len(pts)
+	}
+
+	for id, t := range sub.Trips {
+		shp, ok := trimmed[t.Shape]
+		if !ok {
+			continue
+		}
+		if shp == nil {
+			writer.change("trips.txt", t.Id, "shape_id", ChangeDropped, t.Shape.Id, "")
+		}
+		cp := *t
+		cp.Shape = shp
+		sub.Trips[id] = &cp
+	}
+
+	for tk, tv := range sub.Transfers {
+		key := tk
+		if tk.From_trip != nil {
+			key.From_trip = sub.Trips[tk.From_trip.Id]
+		}
+		if tk.To_trip != nil {
+			key.To_trip = sub.Trips[tk.To_trip.Id]
+		}
+		if key == tk {
+			continue
+		}
+		delete(sub.Transfers, tk)
+		sub.Transfers[key] = tv
+		for _, vals := range sub.TransfersAddFlds {
+			if v, ok := vals[tk]; ok {
+				delete(vals, tk)
+				vals[key] = v
+			}
+		}
+	}
+}
+
+// filterDependents reduces the entities of sub which the trips of sub
+// depend on to the ones used by these trips
+func (writer *Writer) filterDependents(feed *gtfsparser.Feed, sub *gtfsparser.Feed) {
+	usedAgencies := make(map[*gtfs.Agency]bool)
+	anyAgency := false
+	for _, r := range sub.Routes {
+		if r.Agency == nil {
+			anyAgency = true
+		}
+		usedAgencies[r.Agency] = true
+	}
+
+	sub.Agencies = make(map[string]*gtfs.Agency)
+	for id, a := range feed.Agencies {
+		if usedAgencies[a] || anyAgency {
+			sub.Agencies[id] = a
+		} else {
+			writer.change("agency.txt", a.Id, "", ChangeDropped, "", "")
+		}
+	}
+
+	usedStops := make(map[*gtfs.Stop]bool)
+	usedShapes := make(map[*gtfs.Shape]bool)
+	usedServices := make(map[*gtfs.Service]bool)
+	for _, t := range sub.Trips {
+		for i := range t.StopTimes {
+			usedStops[t.StopTimes[i].Stop()] = true
+		}
+		if t.Shape != nil {
+			usedShapes[t.Shape] = true
+		}
+		if t.Service != nil {
+			usedServices[t.Service] = true
+		}
+	}
+
+	// parent stations of served stops are kept
+	for s := range usedStops {
+		for p := s.Parent_station; p != nil && !usedStops[p]; p = p.Parent_station {
+			usedStops[p] = true
+		}
+	}
+
+	// the other stops, stations and orphaned entrances, nodes and
+	// boarding areas are excluded, the exclusion cascade drops the
+	// entrances, nodes and boarding areas within them, and the levels,
+	// pathways and transfers depending on them
+	for _, s := range feed.Stops {
+		if !usedStops[s] && (s.Location_type < 2 || s.Parent_station == nil) {
+			writer.excl.stops[s] = true
+		}
+	}
+
+	sub.Shapes = make(map[string]*gtfs.Shape)
+	sub.NumShpPoints = 0
+	for id, s := range feed.Shapes {
+		if usedShapes[s] {
+			sub.Shapes[id] = s
+			sub.NumShpPoints += len(s.Points)
+		} else {
+			writer.change("shapes.txt", s.Id, "", ChangeDropped, "", "")
+		}
+	}
+
+	sub.Services = make(map[string]*gtfs.Service)
+	for id, s := range feed.Services {
+		if usedServices[s] {
+			sub.Services[id] = s
+		} else {
+			writer.change("calendar.txt", s.Id(), "", ChangeDropped, "", "")
+		}
+	}
+
+	// transfers refer to the written, possibly trimmed copies of trips
+	sub.Transfers = make(map[gtfs.TransferKey]gtfs.TransferVal)
+	sub.TransfersAddFlds = make(map[string]map[gtfs.TransferKey]string, len(feed.TransfersAddFlds))
+	for name := range feed.TransfersAddFlds {
+		sub.TransfersAddFlds[name] = make(map[gtfs.TransferKey]string)
+	}
+	for tk, tv := range feed.Transfers {
+		if (tk.From_route == nil || sub.Routes[tk.From_route.Id] == tk.From_route) && (tk.To_route == nil || sub.Routes[tk.To_route.Id] == tk.To_route) &&
+			(tk.From_trip == nil || sub.Trips[tk.From_trip.Id] != nil) && (tk.To_trip == nil || sub.Trips[tk.To_trip.Id] != nil) {
+			key := tk
+			if tk.From_trip != nil {
+				key.From_trip = sub.Trips[tk.From_trip.Id]
+			}
+			if tk.To_trip != nil {
+				key.To_trip = sub.Trips[tk.To_trip.Id]
+			}
+			sub.Transfers[key] = tv
+			for name, vals := range feed.TransfersAddFlds {
+				if v, ok := vals[tk]; ok {
+					sub.TransfersAddFlds[name][key] = v
+				}
+			}
+		} else {
+			writer.change("transfers.txt", transferId(tk), "", ChangeDropped, "", "")
+		}
+	}
+
+	sub.FareAttributes = make(map[string]*gtfs.FareAttribute)
+	for id, fa := range feed.FareAttributes {
+		if fa.Agency != nil && sub.Agencies[fa.Agency.Id] != fa.Agency {
+			writer.change("fare_attributes.txt", fa.Id, "", ChangeDropped, "", "")
+			continue
+		}
+
+		rules := make([]*gtfs.FareAttributeRule, 0, len(fa.Rules))
+		for _, r := range fa.Rules {
+			if r.Route == nil || sub.Routes[r.Route.Id] == r.Route {
+				rules = append(rules, r)
+			}
+		}
+
+		if len(rules) == len(fa.Rules) {
+			sub.FareAttributes[id] = fa
+		} else if len(rules) > 0 {
+			cp := *fa
+			cp.Rules = rules
+			sub.FareAttributes[id] = &cp
+		} else {
+			// all rules of the fare applied to dropped routes
+			writer.change("fare_attributes.txt", fa.Id, "", ChangeDropped, "", "")
+		}
+	}
+}
+
+// idSet returns a set of the given IDs
+func idSet(ids []string) map[string]bool {
+	ret := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		ret[id] = true
+	}
+	return ret
+}
@@ -0,0 +1,98 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"strings"
+	"testing"
+)
+
+// stationFeed returns a feed with two stations, each with a platform
+// served by its own route, an entrance, a pathway and a level
+func stationFeed() *gtfsparser.Feed {
+	feed := gtfsparser.NewFeed()
+
+	tz, _ := gtfs.NewTimezone("Europe/Berlin")
+	ag := &gtfs.Agency{Id: "A", Name: "Agency", Timezone: tz}
+	feed.Agencies["A"] = ag
+
+	svc := gtfs.NewService("S", 127, gtfs.NewDate(1, 1, 2024), gtfs.NewDate(31, 12, 2024), nil)
+	feed.Services["S"] = svc
+
+	for _, n := range []string{"1", "2"} {
+		lvl := &gtfs.Level{Id: "L" + n, Index: 0}
+		feed.Levels[lvl.Id] = lvl
+
+		station := &gtfs.Stop{Id: "ST" + n, Name: "Station " + n, Lat: 48, Lon: 7.8, Location_type: 1}
+		platform := &gtfs.Stop{Id: "P" + n, Name: "Platform " + n, Lat: 48, Lon: 7.8, Parent_station: station, Level: lvl}
+		entrance := &gtfs.Stop{Id: "E" + n, Name: "Entrance " + n, Lat: 48, Lon: 7.8, Location_type: 2, Parent_station: station, Level: lvl}
+		feed.Stops[station.Id] = station
+		feed.Stops[platform.Id] = platform
+		feed.Stops[entrance.Id] = entrance
+
+		feed.Pathways["PW"+n] = &gtfs.Pathway{Id: "PW" + n, From_stop: entrance, To_stop: platform, Mode: 1, Is_bidirectional: true}
+
+		route := &gtfs.Route{Id: "R" + n, Agency: ag, Short_name: n, Type: 3}
+		feed.Routes[route.Id] = route
+
+		headsign := ""
+		trip := &gtfs.Trip{Id: "T" + n, Route: route, Service: svc, Headsign: &headsign}
+		for i := 0; i < 2; i++ {
+			st := gtfs.StopTime{}
+			st.SetStop(platform)
+			st.SetSequence(i + 1)
+			st.SetArrival_time(gtfs.Time{Hour: 8, Minute: int8(i)})
+			st.SetDeparture_time(gtfs.Time{Hour: 8, Minute: int8(i)})
+			st.SetHeadsign(&headsign)
+			trip.StopTimes = append(trip.StopTimes, st)
+		}
+		feed.Trips[trip.Id] = trip
+	}
+
+	feed.Transfers[gtfs.TransferKey{From_stop: feed.Stops["P1"], To_stop: feed.Stops["P2"]}] = gtfs.TransferVal{Transfer_type: 2, Min_transfer_time: 60}
+	feed.Transfers[gtfs.TransferKey{From_stop: feed.Stops["P1"], To_stop: feed.Stops["P1"]}] = gtfs.TransferVal{Transfer_type: 2, Min_transfer_time: 30}
+
+	return feed
+}
+
+func TestFilterCascade(t *testing.T) {
+	w := Writer{Filter: Filter{RouteIds: []string{"R1"}}}
+
+	files, e := w.WriteToMemory(stationFeed())
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	stops := string(files["stops.txt"])
+	for _, id := range []string{"ST1", "P1", "E1"} {
+		if !strings.Contains(stops, id+",") {
+			t.Errorf("stop %s of the kept station not written:\n%s", id, stops)
+		}
+	}
+	for _, id := range []string{"ST2", "P2", "E2"} {
+		if strings.Contains(stops, id+",") {
+			t.Errorf("stop %s of the filtered station written:\n%s", id, stops)
+		}
+	}
+
+	pathways := string(files["pathways.txt"])
+	if !strings.Contains(pathways, "PW1") || strings.Contains(pathways, "PW2") {
+		t.Errorf("pathways of the filtered station not dropped:\n%s", pathways)
+	}
+
+	levels := string(files["levels.txt"])
+	if !strings.Contains(levels, "L1") || strings.Contains(levels, "L2") {
+		t.Errorf("levels of the filtered station not dropped:\n%s", levels)
+	}
+
+	transfers := string(files["transfers.txt"])
+	if strings.Contains(transfers, "P2") || !strings.Contains(transfers, "P1,P1") {
+		t.Errorf("transfers to the filtered station not dropped:\n%s", transfers)
+	}
+}
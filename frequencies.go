@@ -0,0 +1,91 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"fmt"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"sort"
+)
+
+// An OverlapPolicy defines how overlapping frequency windows of
+// the same trip are handled
+type OverlapPolicy int
+
+const (
+	// OverlapKeep writes overlapping windows as they are
+	OverlapKeep OverlapPolicy = iota
+	// OverlapWarn writes overlapping windows and reports a warning
+	OverlapWarn
+	// OverlapMerge merges overlapping windows with identical headway
+	// and exact_times, and reports a warning for all others
+	OverlapMerge
+)
+
+// freqLine is a frequency window to be written, along with the
+// original frequency holding its additional fields
+type freqLine struct {
+	freq *gtfs.Frequency
+	orig *gtfs.Frequency
+}
+
+type freqLines []freqLine
+
+func (fl freqLines) Len() int      { return len(fl) }
+func (fl freqLines) Swap(i, j int) { fl[i], fl[j] = fl[j], fl[i] }
+func (fl freqLines) Less(i, j int) bool {
+	a := timeToSeconds(fl[i].freq.Start_time)
+	b := timeToSeconds(fl[j].freq.Start_time)
+	if a != b {
+		return a < b
+	}
+	return timeToSeconds(fl[i].freq.End_time) < timeToSeconds(fl[j].freq.End_time)
+}
+
+// tripFrequencies returns the frequency windows of a trip ordered by
+// start time, with overlaps handled according to FrequencyOverlaps
+func (writer *Writer) tripFrequencies(t *gtfs.Trip) freqLines {
+	lines := make(freqLines, 0, len(*t.Frequencies))
+	for _, f := range *t.Frequencies {
+		lines = append(lines, freqLine{f, f})
+	}
+
+	sort.Stable(lines)
+
+	if writer.FrequencyOverlaps == OverlapKeep || len(lines) < 2 {
+		return lines
+	}
+
+	ret := make(freqLines, 0, len(lines))
+	ret = append(ret, lines[0])
+
+	for _, l := range lines[1:] {
+		prev := &ret[len(ret)-1]
+
+		if timeToSeconds(l.freq.Start_time) >= timeToSeconds(prev.freq.End_time) {
+			ret = append(ret, l)
+			continue
+		}
+
+		if writer.FrequencyOverlaps == OverlapMerge && l.freq.Headway_secs == prev.freq.Headway_secs && l.freq.Exact_times == prev.freq.Exact_times {
+			if timeToSeconds(l.freq.End_time) > timeToSeconds(prev.freq.End_time) {
+				merged := *prev.freq
+				merged.End_time = l.freq.End_time
+				writer.change("frequencies.txt", t.Id, "end_time", ChangeMerged, timeToString(prev.freq.End_time), timeToString(merged.End_time))
+				prev.freq = &merged
+			} else {
+				writer.change("frequencies.txt", t.Id, "start_time", ChangeMerged, timeToString(l.freq.Start_time), timeToString(prev.freq.Start_time))
+			}
+			continue
+		}
+
+		writer.warn("frequencies.txt", t.Id, "start_time", fmt.Sprintf("window %s-%s overlaps window %s-%s", timeToString(l.freq.Start_time), timeToString(l.freq.End_time), timeToString(prev.freq.Start_time), timeToString(prev.freq.End_time)))
+		ret = append(ret, l)
+	}
+
+	return ret
+}
@@ -0,0 +1,48 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"math"
+)
+
+const earthRadius = 6371000.0
+
+// haversineDist returns the distance in meters between two coordinates
+func haversineDist(latA float64, lonA float64, latB float64, lonB float64) float64 {
+	dLat := (latB - latA) * math.Pi / 180
+	dLon := (lonB - lonA) * math.Pi / 180
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(latA*math.Pi/180)*math.Cos(latB*math.Pi/180)*math.Sin(dLon/2)*math.Sin(dLon/2)
+
+	return earthRadius * 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// segmentDist returns the approximate distance in meters between a
+// coordinate and the line segment between two other coordinates,
+// using an equirectangular projection around the coordinate
+func segmentDist(lat float64, lon float64, latA float64, lonA float64, latB float64, lonB float64) float64 {
+	k := math.Cos(lat * math.Pi / 180)
+
+	ax := (lonA - lon) * k
+	ay := latA - lat
+	bx := (lonB - lon) * k
+	by := latB - lat
+
+	dx := bx - ax
+	dy := by - ay
+
+	t := 0.0
+	if l := dx*dx + dy*dy; l > 0 {
+		t = math.Max(0, math.Min(1, -(ax*dx+ay*dy)/l))
+	}
+
+	px := ax + t*dx
+	py := ay + t*dy
+
+	return math.Sqrt(px*px+py*py) * math.Pi / 180 * earthRadius
+}
@@ -0,0 +1,65 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"compress/gzip"
+	"io"
+)
+
+// gzipFile is a file in an output directory written gzip compressed
+type gzipFile struct {
+	gz *gzip.Writer
+	f  io.WriteCloser
+}
+
+// newGzipFile compresses the output of f, which is written to out,
+// with ZipCompressionLevel
+func (writer *Writer) newGzipFile(f io.WriteCloser, out io.Writer) (*gzipFile, error) {
+	level := gzip.DefaultCompression
+	if writer.ZipCompressionLevel == -1 {
+		level = gzip.NoCompression
+	} else if writer.ZipCompressionLevel > 0 {
+		level = writer.ZipCompressionLevel
+	}
+
+	gz, e := gzip.NewWriterLevel(out, level)
+	if e != nil {
+		return nil, e
+	}
+
+	return &gzipFile{gz, f}, nil
+}
+
+func (g *gzipFile) Write(p []byte) (int, error) {
+	return g.gz.Write(p)
+}
+
+// Sync finishes the gzip stream and syncs the file, nothing can be
+// written afterwards
+func (g *gzipFile) Sync() error {
+	if e := g.gz.Close(); e != nil {
+		return e
+	}
+	return syncFile(g.f)
+}
+
+func (g *gzipFile) Close() error {
+	e := g.gz.Close()
+	if ce := g.f.Close(); e == nil {
+		e = ce
+	}
+	return e
+}
+
+// fileName returns the name of a file written to an output directory
+func (writer *Writer) fileName(name string) string {
+	if writer.GzipFiles {
+		return name + ".gz"
+	}
+	return name
+}
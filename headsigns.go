@@ -0,0 +1,60 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"sort"
+)
+
+// fillHeadsigns derives missing trip headsigns from the name of the
+// trip's last stop (or its parent station) if FillHeadsigns is set
+func (writer *Writer) fillHeadsigns(feed *gtfsparser.Feed) {
+	writer.headsigns = make(map[*gtfs.Trip]string)
+
+	if !writer.FillHeadsigns {
+		return
+	}
+
+	for _, t := range feed.Trips {
+		if (t.Headsign != nil && len(*t.Headsign) > 0) || len(t.StopTimes) == 0 {
+			continue
+		}
+
+		last := writer.stop(t.StopTimes[len(t.StopTimes)-1].Stop())
+		if last == nil {
+			continue
+		}
+
+		name := writer.stopName(last)
+		if parent := writer.parentStation(last); parent != nil && len(parent.Name) > 0 {
+			name = writer.stopName(parent)
+		}
+
+		if len(name) == 0 {
+			continue
+		}
+
+		writer.headsigns[t] = name
+		writer.Report.FilledHeadsigns = append(writer.Report.FilledHeadsigns, t.Id)
+		writer.change("trips.txt", t.Id, "trip_headsign", ChangeFilled, "", name)
+	}
+
+	sort.Strings(writer.Report.FilledHeadsigns)
+}
+
+// headsign returns the headsign written for a trip
+func (writer *Writer) headsign(t *gtfs.Trip) string {
+	if h, ok := writer.headsigns[t]; ok {
+		return h
+	}
+	if t.Headsign == nil {
+		return ""
+	}
+	return *t.Headsign
+}
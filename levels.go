@@ -0,0 +1,99 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"fmt"
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A MissingRefPolicy defines how references to entities which are
+// not part of the feed are handled
+type MissingRefPolicy int
+
+const (
+	// MissingRefKeep writes the dangling reference as is
+	MissingRefKeep MissingRefPolicy = iota
+	// MissingRefCreate writes a placeholder for the missing entity
+	MissingRefCreate
+	// MissingRefFail aborts writing
+	MissingRefFail
+)
+
+var levelIndexRegex = regexp.MustCompile(`(-?[0-9]+)\s*$`)
+
+// prepareLevels handles levels referenced by stops which are missing
+// in feed.Levels, according to the MissingLevels policy
+func (writer *Writer) prepareLevels(feed *gtfsparser.Feed) error {
+	writer.genLevels = nil
+
+	if writer.MissingLevels == MissingRefKeep {
+		return nil
+	}
+
+	missing := make(map[string]*gtfs.Level)
+	refs := make(map[string][]string)
+
+	for _, s := range feed.Stops {
+		if s.Level == nil || feed.Levels[s.Level.Id] != nil || writer.excl.stops[s] {
+			continue
+		}
+		missing[s.Level.Id] = s.Level
+		refs[s.Level.Id] = append(refs[s.Level.Id], s.Id)
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(missing))
+	for id := range missing {
+		ids = append(ids, id)
+		sort.Strings(refs[id])
+	}
+	sort.Strings(ids)
+
+	if writer.MissingLevels == MissingRefFail {
+		list := make([]string, len(ids))
+		for i, id := range ids {
+			list[i] = fmt.Sprintf("'%s' (stops %s)", id, strings.Join(refs[id], ", "))
+		}
+		return writeError{"levels.txt", "stops reference missing levels: " + strings.Join(list, "; ")}
+	}
+
+	for _, id := range ids {
+		l := missing[id]
+		writer.genLevels = append(writer.genLevels, &gtfs.Level{Id: l.Id, Index: levelIndex(l), Name: l.Name})
+		writer.change("levels.txt", id, "", ChangeCreated, "", "")
+		writer.warn("levels.txt", id, "level_id", fmt.Sprintf("created placeholder for missing level referenced by stops %s", strings.Join(refs[id], ", ")))
+	}
+
+	return nil
+}
+
+// levelIndex returns the index of a level, derived from a trailing
+// number in the level's ID or name if no index is set
+func levelIndex(l *gtfs.Level) float32 {
+	if l.Index != 0 {
+		return l.Index
+	}
+
+	for _, name := range []string{l.Id, l.Name} {
+		if m := levelIndexRegex.FindStringSubmatch(name); m != nil {
+			if i, err := strconv.Atoi(m[1]); err == nil {
+				return float32(i)
+			}
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,110 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"bytes"
+	"github.com/patrickbr/gtfsparser"
+	"io"
+	"io/fs"
+	opath "path"
+	"sync"
+	"time"
+)
+
+// WriteToMemory writes a feed like Write, but returns the written
+// files by name instead of touching the disk. All settings for output
+// directories apply.
+func (writer *Writer) WriteToMemory(feed *gtfsparser.Feed) (map[string][]byte, error) {
+	writer.mem = &memBackend{files: make(map[string]*bytes.Buffer)}
+
+	defer func() {
+		writer.mem = nil
+	}()
+
+	if e := writer.Write(feed, "."); e != nil {
+		return nil, e
+	}
+
+	ret := make(map[string][]byte, len(writer.mem.files))
+	for name, buf := range writer.mem.files {
+		ret[name] = buf.Bytes()
+	}
+
+	return ret, nil
+}
+
+// memBackend holds the files written by WriteToMemory, the output
+// directory is "."
+type memBackend struct {
+	mutex sync.Mutex
+	files map[string]*bytes.Buffer
+}
+
+func (m *memBackend) Create(name string) (io.WriteCloser, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	buf := new(bytes.Buffer)
+	m.files[name] = buf
+
+	return memFile{buf}, nil
+}
+
+func (m *memBackend) Remove(name string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.files[name]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+
+	delete(m.files, name)
+
+	return nil
+}
+
+func (m *memBackend) Stat(name string) (fs.FileInfo, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if name == "." {
+		return dirInfo("."), nil
+	}
+
+	buf, ok := m.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return memFileInfo{opath.Base(name), int64(buf.Len())}, nil
+}
+
+type memFile struct {
+	buf *bytes.Buffer
+}
+
+func (f memFile) Write(p []byte) (int, error) {
+	return f.buf.Write(p)
+}
+
+func (f memFile) Close() error {
+	return nil
+}
+
+// memFileInfo is the fs.FileInfo of a file written to memory
+type memFileInfo struct {
+	name string
+	size int64
+}
+
+func (fi memFileInfo) Name() string       { return fi.name }
+func (fi memFileInfo) Size() int64        { return fi.size }
+func (fi memFileInfo) Mode() fs.FileMode  { return 0644 }
+func (fi memFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi memFileInfo) IsDir() bool        { return false }
+func (fi memFileInfo) Sys() interface{}   { return nil }
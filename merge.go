@@ -0,0 +1,141 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"sort"
+)
+
+type stopMergeKey struct {
+	name    string
+	locType int8
+	parent  *gtfs.Stop
+}
+
+// mergeStops merges stops with identical names, location types and
+// parent stations which are at most MergeStopsDist meters away from
+// each other into the stop with the smallest ID. The feed itself is
+// not modified, references are resolved during writing.
+func (writer *Writer) mergeStops(feed *gtfsparser.Feed) {
+	writer.stopMap = make(map[*gtfs.Stop]*gtfs.Stop)
+
+	if writer.MergeStopsDist <= 0 {
+		return
+	}
+
+	groups := make(map[stopMergeKey]stopsById)
+	for _, s := range feed.Stops {
+		if !s.HasLatLon() || writer.excl.stops[s] {
+			continue
+		}
+		key := stopMergeKey{s.Name, s.Location_type, s.Parent_station}
+		groups[key] = append(groups[key], s)
+	}
+
+	for _, stops := range groups {
+		if len(stops) < 2 {
+			continue
+		}
+		sort.Sort(stops)
+
+		for _, cluster := range clusterStops(stops, writer.MergeStopsDist) {
+			for _, s := range cluster[1:] {
+				writer.stopMap[s] = cluster[0]
+				writer.Report.MergedStops[s.Id] = cluster[0].Id
+				writer.change("stops.txt", s.Id, "", ChangeMerged, s.Id, cluster[0].Id)
+			}
+		}
+	}
+}
+
+type routeMergeKey struct {
+	agency    *gtfs.Agency
+	shortName string
+	longName  string
+}
+
+// mergeRoutes merges routes with identical agencies, short names and
+// long names into the route with the smallest ID if MergeRoutes is set.
+// The feed itself is not modified, the trips of merged routes are
+// written with the surviving route.
+func (writer *Writer) mergeRoutes(feed *gtfsparser.Feed) {
+	writer.routeMap = make(map[*gtfs.Route]*gtfs.Route)
+
+	if !writer.MergeRoutes {
+		return
+	}
+
+	groups := make(map[routeMergeKey]*gtfs.Route)
+	for _, r := range feed.Routes {
+		key := routeMergeKey{r.Agency, r.Short_name, r.Long_name}
+		if cur, ok := groups[key]; !ok || r.Id < cur.Id {
+			groups[key] = r
+		}
+	}
+
+	for _, r := range feed.Routes {
+		survivor := groups[routeMergeKey{r.Agency, r.Short_name, r.Long_name}]
+		if survivor != r {
+			writer.routeMap[r] = survivor
+			writer.Report.MergedRoutes[r.Id] = survivor.Id
+			writer.change("routes.txt", r.Id, "", ChangeMerged, r.Id, survivor.Id)
+		}
+	}
+}
+
+// route returns the route written in place of r
+func (writer *Writer) route(r *gtfs.Route) *gtfs.Route {
+	if m, ok := writer.routeMap[r]; ok {
+		return m
+	}
+	return r
+}
+
+// stop returns the stop written in place of s
+func (writer *Writer) stop(s *gtfs.Stop) *gtfs.Stop {
+	if m, ok := writer.stopMap[s]; ok {
+		return m
+	}
+	return s
+}
+
+// merged checks whether a stop has been merged into another one
+func (writer *Writer) merged(s *gtfs.Stop) bool {
+	_, ok := writer.stopMap[s]
+	return ok
+}
+
+// transferKey returns a transfer key with all merged entities resolved
+func (writer *Writer) transferKey(tk gtfs.TransferKey) gtfs.TransferKey {
+	if tk.From_stop != nil {
+		tk.From_stop = writer.stop(tk.From_stop)
+	}
+	if tk.To_stop != nil {
+		tk.To_stop = writer.stop(tk.To_stop)
+	}
+	if tk.From_route != nil {
+		tk.From_route = writer.route(tk.From_route)
+	}
+	if tk.To_route != nil {
+		tk.To_route = writer.route(tk.To_route)
+	}
+	return tk
+}
+
+// pathway returns a pathway with all merged stops resolved
+func (writer *Writer) pathway(p *gtfs.Pathway) *gtfs.Pathway {
+	if !writer.merged(p.From_stop) && !writer.merged(p.To_stop) {
+		return p
+	}
+
+	ret := *p
+	ret.From_stop = writer.stop(p.From_stop)
+	ret.To_stop = writer.stop(p.To_stop)
+	return &ret
+}
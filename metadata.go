@@ -0,0 +1,255 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+)
+
+const modulePath = "github.com/patrickbr/gtfswriter"
+
+// Metadata describes the writer configuration a feed was written with,
+// so that it can be regenerated later
+type Metadata struct {
+	// version of gtfswriter, "(devel)" if unknown
+	Version string `json:"version"`
+
+	// version of Go the writer was built with
+	GoVersion string `json:"go_version"`
+
+	// all options which differ from their zero value. Auxiliary files
+	// are given by their SHA-256 hash.
+	Options map[string]interface{} `json:"options"`
+
+	// source feed given in MetadataSource, and its SHA-256 hash
+	Source     string `json:"source,omitempty"`
+	SourceHash string `json:"source_sha256,omitempty"`
+}
+
+// Metadata returns the metadata of feeds written with the current
+// settings
+func (writer *Writer) Metadata() (Metadata, error) {
+	md := Metadata{
+		Version:   "(devel)",
+		GoVersion: runtime.Version(),
+		Options:   make(map[string]interface{}),
+	}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if bi.Main.Path == modulePath {
+			md.Version = bi.Main.Version
+		}
+		for _, dep := range bi.Deps {
+			if dep.Path == modulePath {
+				md.Version = dep.Version
+			}
+		}
+	}
+
+	for name, val := range writer.options() {
+		if !reflect.ValueOf(val).IsZero() {
+			md.Options[name] = val
+		}
+	}
+
+	if len(writer.AuxFiles) > 0 {
+		hashes := make(map[string]string, len(writer.AuxFiles))
+		for name, content := range writer.AuxFiles {
+			sum := sha256.Sum256(content)
+			hashes[name] = hex.EncodeToString(sum[:])
+		}
+		md.Options["AuxFiles"] = hashes
+	}
+
+	if len(writer.MetadataSource) > 0 {
+		hash, e := sourceHash(writer.MetadataSource)
+		if e != nil {
+			return md, writeError{writer.MetadataSource, e.Error()}
+		}
+		md.Source = writer.MetadataSource
+		md.SourceHash = hash
+	}
+
+	return md, nil
+}
+
+// options returns the options recorded in the metadata. Callbacks, the
+// output backend and the report are not part of them.
+func (writer *Writer) options() map[string]interface{} {
+	ret := map[string]interface{}{
+		"ZipCompressionLevel":    writer.ZipCompressionLevel,
+		"Sorted":                 writer.Sorted,
+		"SortKeys":               writer.SortKeys,
+		"NaturalSort":            writer.NaturalSort,
+		"ExplicitCalendar":       writer.ExplicitCalendar,
+		"KeepColOrder":           writer.KeepColOrder,
+		"DontGarbageCollect":     writer.DontGarbageCollect,
+		"GenerateShapes":         writer.GenerateShapes,
+		"CurrencyCheck":          writer.CurrencyCheck,
+		"SymmetricTransfers":     writer.SymmetricTransfers,
+		"ReversePathways":        writer.ReversePathways,
+		"MissingLevels":          writer.MissingLevels,
+		"FrequencyOverlaps":      writer.FrequencyOverlaps,
+		"CompactBlockIds":        writer.CompactBlockIds,
+		"DropSingleTripBlocks":   writer.DropSingleTripBlocks,
+		"CompactZoneIds":         writer.CompactZoneIds,
+		"ZoneIdMappingFile":      writer.ZoneIdMappingFile,
+		"GenerateParentStations": writer.GenerateParentStations,
+		"ParentStationDist":      writer.ParentStationDist,
+		"MergeStopsDist":         writer.MergeStopsDist,
+		"MergeRoutes":            writer.MergeRoutes,
+		"FillHeadsigns":          writer.FillHeadsigns,
+		"TripIdTemplate":         writer.TripIdTemplate,
+		"StripHtml":              writer.StripHtml,
+		"TitleCaseStopNames":     writer.TitleCaseStopNames,
+		"TitleCaseLang":          writer.TitleCaseLang,
+		"WheelchairCheck":        writer.WheelchairCheck,
+		"DowngradeWheelchair":    writer.DowngradeWheelchair,
+		"BikesAllowedDefaults":   writer.BikesAllowedDefaults,
+		"DerivePlatformCodes":    writer.DerivePlatformCodes,
+		"TrimPlatformNames":      writer.TrimPlatformNames,
+		"RecordChanges":          writer.RecordChanges,
+		"ChangeReportFile":       writer.ChangeReportFile,
+		"WriteWarnings":          writer.WriteWarnings,
+		"ComputeQuality":         writer.ComputeQuality,
+		"DuplicateCheck":         writer.DuplicateCheck,
+		"DropDuplicates":         writer.DropDuplicates,
+		"StopTimesCheck":         writer.StopTimesCheck,
+		"ConflictCheck":          writer.ConflictCheck,
+		"PartialFeed":            writer.PartialFeed,
+		"ShapeDistCheck":         writer.ShapeDistCheck,
+		"MaxShapeDist":           writer.MaxShapeDist,
+		"SchemaFile":             writer.SchemaFile,
+		"BatchWorkers":           writer.BatchWorkers,
+		"CopyUnknownFrom":        writer.CopyUnknownFrom,
+		"Debug":                  writer.Debug,
+		"Retries":                writer.Retries,
+		"RetryBackoff":           writer.RetryBackoff,
+		"Preflight":              writer.Preflight,
+		"Durable":                writer.Durable,
+		"CleanupOnError":         writer.CleanupOnError,
+		"SecondsColumns":         writer.SecondsColumns,
+		"FileTimeout":            writer.FileTimeout,
+		"MaxBytesPerSec":         writer.MaxBytesPerSec,
+		"MetadataFile":           writer.MetadataFile,
+		"MetadataSource":         writer.MetadataSource,
+		"SourceDistUnit":         writer.SourceDistUnit,
+		"TargetDistUnit":         writer.TargetDistUnit,
+		"DistDecimals":           writer.DistDecimals,
+		"FalseValues":            writer.FalseValues,
+		"CalendarMinDays":        writer.CalendarMinDays,
+		"StreamStopTimes":        writer.StreamStopTimes,
+		"Parallelism":            writer.Parallelism,
+		"OnlyFiles":              writer.OnlyFiles,
+		"SkipFiles":              writer.SkipFiles,
+		"DryRun":                 writer.DryRun,
+		"StrictWrite":            writer.StrictWrite,
+		"ContinueOnError":        writer.ContinueOnError,
+		"Compression":            writer.Compression,
+		"GzipFiles":              writer.GzipFiles,
+		"SkipUnchanged":          writer.SkipUnchanged,
+		"Atomic":                 writer.Atomic,
+		"Quoting":                writer.Quoting,
+		"KeepAllColumns":         writer.KeepAllColumns,
+		"ExplicitDefaults":       writer.ExplicitDefaults,
+		"KeepNewlines":           writer.KeepNewlines,
+		"Checksums":              writer.Checksums,
+		"ChecksumFile":           writer.ChecksumFile,
+		"ZipComment":             writer.ZipComment,
+		"ZipModified":            writer.ZipModified,
+		"QualityHorizonStart":    writer.QualityHorizonStart,
+		"QualityHorizonDays":     writer.QualityHorizonDays,
+	}
+
+	if writer.Filter.active() {
+		filter := map[string]interface{}{}
+		if len(writer.Filter.AgencyIds) > 0 {
+			filter["AgencyIds"] = writer.Filter.AgencyIds
+		}
+		if len(writer.Filter.RouteIds) > 0 {
+			filter["RouteIds"] = writer.Filter.RouteIds
+		}
+		if !writer.Filter.StartDate.IsEmpty() {
+			filter["StartDate"] = dateToString(writer.Filter.StartDate)
+		}
+		if !writer.Filter.EndDate.IsEmpty() {
+			filter["EndDate"] = dateToString(writer.Filter.EndDate)
+		}
+		if writer.Filter.Box != nil {
+			filter["Box"] = *writer.Filter.Box
+		}
+		ret["Filter"] = filter
+	}
+
+	return ret
+}
+
+// sourceHash returns the SHA-256 hash of a source feed. For a
+// directory, the names and contents of all files are hashed in
+// lexical order.
+func sourceHash(path string) (string, error) {
+	h := sha256.New()
+
+	e := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		if p != path {
+			rel, err := filepath.Rel(path, p)
+			if err != nil {
+				return err
+			}
+			io.WriteString(h, filepath.ToSlash(rel)+"\x00")
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(h, f)
+		return err
+	})
+
+	if e != nil {
+		return "", e
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeMetadata writes the metadata of the feed to MetadataFile
+func (writer *Writer) writeMetadata() error {
+	if len(writer.MetadataFile) == 0 {
+		return nil
+	}
+
+	md, e := writer.Metadata()
+	if e != nil {
+		return e
+	}
+
+	// encoded before the file is created, so that a failure leaves
+	// no file behind
+	data, e := json.MarshalIndent(md, "", "  ")
+	if e != nil {
+		return writeError{writer.MetadataFile, e.Error()}
+	}
+
+	return writer.writeSidecar(writer.MetadataFile, append(data, '\n'))
+}
@@ -0,0 +1,62 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"sort"
+)
+
+// naturalTripLines are ordered like tripLines, but with route and
+// trip IDs compared in natural order
+type naturalTripLines struct{ tripLines }
+
+func (tl naturalTripLines) Less(i, j int) bool {
+	a := tl.tripLines[i].Trip
+	b := tl.tripLines[j].Trip
+
+	if a.Route.Type != b.Route.Type {
+		return a.Route.Type < b.Route.Type
+	}
+	if a.Route.Long_name != b.Route.Long_name {
+		return a.Route.Long_name < b.Route.Long_name
+	}
+	if *a.Headsign != *b.Headsign {
+		return *a.Headsign < *b.Headsign
+	}
+	if c := compareNatural(a.Route.Id, b.Route.Id); c != 0 {
+		return c < 0
+	}
+	return compareNatural(a.Id, b.Id) < 0
+}
+
+// naturalShapeLines are ordered like shapeLines, but with shape IDs
+// compared in natural order
+type naturalShapeLines struct{ shapeLines }
+
+func (sl naturalShapeLines) Less(i, j int) bool {
+	return compareNatural(sl.shapeLines[i].Shape.Id, sl.shapeLines[j].Shape.Id) < 0
+}
+
+// sortTrips sorts the trips whose stop times are written, in natural
+// order if NaturalSort is set
+func (writer *Writer) sortTrips(lines tripLines) {
+	if writer.NaturalSort {
+		sort.Sort(naturalTripLines{lines})
+	} else {
+		sort.Sort(lines)
+	}
+}
+
+// sortShapes sorts the shapes whose points are written, in natural
+// order if NaturalSort is set
+func (writer *Writer) sortShapes(lines shapeLines) {
+	if writer.NaturalSort {
+		sort.Sort(naturalShapeLines{lines})
+	} else {
+		sort.Sort(lines)
+	}
+}
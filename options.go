@@ -0,0 +1,47 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"github.com/patrickbr/gtfsparser"
+)
+
+// An Option changes the settings of a Writer for a single call to
+// WriteWithOptions
+type Option func(*Writer)
+
+// WriteWithOptions writes a feed like Write, but applies the given
+// options to a copy of the writer first. The writer itself is not
+// modified, its Report is left untouched and the report of this
+// call is returned instead. This allows a single, shared writer to
+// be used with different settings concurrently.
+func (writer *Writer) WriteWithOptions(feed *gtfsparser.Feed, path string, opts ...Option) (Report, error) {
+	w := writer.clone()
+
+	for _, opt := range opts {
+		opt(w)
+	}
+
+	err := w.Write(feed, path)
+
+	return w.Report, err
+}
+
+// clone returns a copy of the writer's settings. Per-write state is
+// reset at the beginning of Write, only the output handles have to be
+// detached from the original writer, and the clone gets its own lists
+// of extra files and tables.
+func (writer *Writer) clone() *Writer {
+	w := *writer
+	w.curFileHandle = nil
+	w.zipFile = nil
+	w.zipHandle = nil
+	w.Report = Report{}
+	w.extraFiles = append([]extraFile(nil), writer.extraFiles...)
+	w.extraTables = append([]extraTable(nil), writer.extraTables...)
+	return &w
+}
@@ -0,0 +1,112 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	"runtime"
+	"sync"
+)
+
+// parallel checks whether the tables of a feed written to path can be
+// written concurrently. Only files in a directory are independent, a
+// ZIP file is written sequentially. MaxBytesPerSec limits the total
+// throughput and thus also requires a single writer.
+func (writer *Writer) parallel(path string) bool {
+	if writer.Parallelism <= 1 || writer.single != nil || writer.extZip != nil || writer.MaxBytesPerSec > 0 {
+		return false
+	}
+
+	fi, e := writer.backend().Stat(path)
+
+	return e == nil && fi.IsDir()
+}
+
+// writeParallel writes the tables to the directory path with up to
+// Parallelism workers. Each table is written by its own copy of the
+// writer, which shares the prepared state of the feed, and whose
+// results are merged back in table order afterwards.
+func (writer *Writer) writeParallel(attributions entAttrs, path string, feed *gtfsparser.Feed) error {
+	tables, e := writer.selectedTables(attributions)
+	if e != nil {
+		return e
+	}
+
+	writers := make([]*Writer, len(tables))
+	errs := make([]error, len(tables))
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+
+	for i := 0; i < writer.Parallelism && i < len(tables); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				w := writer.tableWriter()
+				writers[i] = w
+
+				// the table must be written by the copy
+				copies, _ := w.selectedTables(attributions)
+				t := copies[i]
+
+				errs[i] = w.canceled(t.name)
+				if errs[i] == nil {
+					errs[i] = w.writeRetrying(t, path, feed)
+				}
+			}
+		}()
+	}
+
+	for i := range tables {
+		next <- i
+	}
+	close(next)
+
+	wg.Wait()
+
+	if !writer.DontGarbageCollect {
+		runtime.GC()
+	}
+
+	for _, w := range writers {
+		writer.Report.Files = append(writer.Report.Files, w.Report.Files...)
+		writer.Report.Changes = append(writer.Report.Changes, w.Report.Changes...)
+		writer.Report.InvalidStopTimes = append(writer.Report.InvalidStopTimes, w.Report.InvalidStopTimes...)
+		writer.warnings = append(writer.warnings, w.warnings...)
+		writer.created = append(writer.created, w.created...)
+	}
+
+	for i, e := range errs {
+		if e == nil {
+			continue
+		}
+		if fe := writer.tableFailed(path, tables[i].name, e); fe != nil {
+			return fe
+		}
+	}
+
+	return nil
+}
+
+// tableWriter returns a copy of the writer for writing a single table
+// concurrently to others. The per-write state prepared for the feed is
+// only read while writing and shared, the state of the file written
+// and the results collected while writing are the copy's own.
+func (writer *Writer) tableWriter() *Writer {
+	w := *writer
+	w.curFileHandle = nil
+	w.curFileName = ""
+	w.sinkErr = nil
+	w.buff = make([]byte, 0, 64)
+	w.warnings = nil
+	w.created = nil
+	w.Report.Files = nil
+	w.Report.Changes = nil
+	w.Report.InvalidStopTimes = nil
+	return &w
+}
@@ -0,0 +1,36 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"github.com/patrickbr/gtfsparser"
+)
+
+// A PartialFeedPolicy defines how tables without entities are
+// written, which allows writing fragments of feeds
+type PartialFeedPolicy int
+
+const (
+	// PartialOff writes required files with their header only,
+	// and skips optional files
+	PartialOff PartialFeedPolicy = iota
+	// PartialSkip skips all files without entities
+	PartialSkip
+	// PartialStubs writes all files without entities with their
+	// header only
+	PartialStubs
+)
+
+// hasStopTimes checks whether any trip of the feed has stop times
+func hasStopTimes(feed *gtfsparser.Feed) bool {
+	for _, t := range feed.Trips {
+		if len(t.StopTimes) > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"regexp"
+	"strings"
+)
+
+// matches trailing platform designators like "Gleis 7", "Gl. 3a",
+// "Bahnsteig B", "Platform 12" or "Voie 2"
+var platformSuffixRe = regexp.MustCompile(`(?i)^(.*?)[\s,\-/]+(?:gleis|gl\.|bahnsteig|bstg\.|steig|platform|plat\.|pl\.|track|bay|stand|quai|voie|spoor|perron|binario|bin\.|and[eé]n)\s*([0-9]+[a-z]?|[a-z])\s*$`)
+
+// platformSuffix extracts a trailing platform designator from the name
+// of a stop without a platform_code. It returns the platform code and
+// the name without the designator.
+func platformSuffix(s *gtfs.Stop) (string, string, bool) {
+	if s.Location_type != 0 || len(s.Platform_code) > 0 {
+		return "", "", false
+	}
+
+	m := platformSuffixRe.FindStringSubmatch(s.Name)
+	if m == nil || len(strings.TrimSpace(m[1])) == 0 {
+		return "", "", false
+	}
+
+	return m[2], strings.TrimSpace(m[1]), true
+}
+
+// platformCode returns the platform_code written for a stop
+func (writer *Writer) platformCode(s *gtfs.Stop) string {
+	if code, ok := writer.platformCodes[s]; ok {
+		return code
+	}
+	return s.Platform_code
+}
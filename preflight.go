@@ -0,0 +1,93 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"errors"
+	"fmt"
+	"github.com/patrickbr/gtfsparser"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// estimated average row sizes in bytes, used to estimate the size of
+// the output
+const (
+	estStopTimeBytes   = 48
+	estShapePointBytes = 40
+	estTripBytes       = 48
+	estStopBytes       = 80
+	estOtherBytes      = 64
+)
+
+// estimated ratio of the uncompressed to the compressed size of a feed
+const estZipRatio = 4
+
+// preflight checks that the output path exists, is writable and has
+// enough free space for the feed, before any work is done. For other
+// backends than the local file system, only the existence is checked.
+func (writer *Writer) preflight(feed *gtfsparser.Feed, path string) error {
+	fileInfo, e := writer.backend().Stat(path)
+	if e != nil {
+		return writeError{path, "output path does not exist"}
+	}
+
+	// other backends cannot be probed
+	if !writer.local() {
+		return nil
+	}
+
+	dir := path
+	if !fileInfo.IsDir() {
+		dir = filepath.Dir(path)
+	}
+
+	probe, e := os.CreateTemp(dir, ".gtfswriter-preflight-*")
+	if errors.Is(e, syscall.EROFS) {
+		return writeError{path, "output path is on a read-only file system"}
+	}
+	if e != nil {
+		return writeError{path, "output path is not writable: " + e.Error()}
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+
+	free, ok := freeSpace(dir)
+	if !ok {
+		return nil
+	}
+
+	size := estimatedSize(feed)
+	if !fileInfo.IsDir() {
+		size /= estZipRatio
+	}
+
+	if uint64(size) > free {
+		return writeError{path, fmt.Sprintf("not enough free space, about %d MB needed, %d MB available", size>>20, free>>20)}
+	}
+
+	return nil
+}
+
+// estimatedSize returns the estimated uncompressed size of a feed
+func estimatedSize(feed *gtfsparser.Feed) int64 {
+	size := int64(0)
+
+	for _, t := range feed.Trips {
+		size += estTripBytes + int64(len(t.StopTimes))*estStopTimeBytes
+	}
+
+	for _, s := range feed.Shapes {
+		size += int64(len(s.Points)) * estShapePointBytes
+	}
+
+	size += int64(len(feed.Stops)) * estStopBytes
+	size += int64(len(feed.Routes)+len(feed.Services)+len(feed.Agencies)+len(feed.Transfers)+len(feed.Pathways)) * estOtherBytes
+
+	return size
+}
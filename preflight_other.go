@@ -0,0 +1,14 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+//go:build !linux && !darwin && !freebsd
+
+package gtfswriter
+
+// freeSpace is not supported on this platform
+func freeSpace(dir string) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,23 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+//go:build linux || darwin || freebsd
+
+package gtfswriter
+
+import (
+	"syscall"
+)
+
+// freeSpace returns the number of bytes available to unprivileged
+// users on the file system of dir
+func freeSpace(dir string) (uint64, bool) {
+	var st syscall.Statfs_t
+	if syscall.Statfs(dir, &st) != nil {
+		return 0, false
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), true
+}
@@ -0,0 +1,120 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"time"
+)
+
+const defQualityHorizonDays = 30
+
+// Quality summarizes the completeness of a written feed. Shares are
+// given in percent of the written entities.
+type Quality struct {
+	Trips    int `json:"trips"`
+	Stops    int `json:"stops"`
+	Routes   int `json:"routes"`
+	Services int `json:"services"`
+
+	// trips with a shape, including generated shapes
+	TripsWithShapes float64 `json:"trips_with_shapes"`
+
+	// stops with a wheelchair_boarding, including inherited values
+	StopsWithWheelchairInfo float64 `json:"stops_with_wheelchair_info"`
+
+	// routes with a route_color or route_text_color
+	RoutesWithColors float64 `json:"routes_with_colors"`
+
+	// services active on at least one day of the horizon
+	ServicesWithinHorizon float64 `json:"services_within_horizon"`
+
+	// first day and length of the horizon
+	HorizonStart string `json:"horizon_start"`
+	HorizonDays  int    `json:"horizon_days"`
+}
+
+func percent(n int, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(n) / float64(total)
+}
+
+// computeQuality fills Report.Quality if ComputeQuality is set
+func (writer *Writer) computeQuality(feed *gtfsparser.Feed) {
+	if !writer.ComputeQuality {
+		return
+	}
+
+	q := &writer.Report.Quality
+
+	shapes := 0
+	for _, t := range feed.Trips {
+		q.Trips++
+		if writer.tripShape(t) != nil {
+			shapes++
+		}
+	}
+	q.TripsWithShapes = percent(shapes, q.Trips)
+
+	wheelchair := 0
+	stops := make([]*gtfs.Stop, 0, len(feed.Stops)+len(writer.genStops))
+	for _, s := range feed.Stops {
+		stops = append(stops, s)
+	}
+	stops = append(stops, writer.genStops...)
+	for _, s := range stops {
+		if writer.excl.stops[s] || writer.merged(s) {
+			continue
+		}
+		q.Stops++
+		if writer.wheelchairBoarding(s) != 0 {
+			wheelchair++
+		}
+	}
+	q.StopsWithWheelchairInfo = percent(wheelchair, q.Stops)
+
+	colors := 0
+	for _, r := range feed.Routes {
+		if _, merged := writer.routeMap[r]; merged {
+			continue
+		}
+		q.Routes++
+		// the parser defaults missing colors to white on black
+		if r.Color != "FFFFFF" || r.Text_color != "000000" {
+			colors++
+		}
+	}
+	q.RoutesWithColors = percent(colors, q.Routes)
+
+	start := writer.QualityHorizonStart
+	if start.IsZero() {
+		start = time.Now()
+	}
+	days := writer.QualityHorizonDays
+	if days <= 0 {
+		days = defQualityHorizonDays
+	}
+	first := gtfs.GetGtfsDateFromTime(start)
+
+	active := 0
+	for _, s := range feed.Services {
+		q.Services++
+		for i := 0; i < days; i++ {
+			if s.IsActiveOn(first.GetOffsettedDate(i)) {
+				active++
+				break
+			}
+		}
+	}
+	q.ServicesWithinHorizon = percent(active, q.Services)
+
+	q.HorizonStart = dateToString(first)
+	q.HorizonDays = days
+}
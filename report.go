@@ -0,0 +1,92 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"time"
+)
+
+// A Report holds information about the changes the writer applied
+// to a feed during the last call to Write
+type Report struct {
+	// mapping of original to written zone IDs
+	ZoneIds map[string]string
+
+	// generated stations and the IDs of their platforms
+	ParentStations map[string][]string
+
+	// mapping of merged stop IDs to the IDs of the stops they were merged into
+	MergedStops map[string]string
+
+	// mapping of merged route IDs to the IDs of the routes they were merged into
+	MergedRoutes map[string]string
+
+	// IDs of the trips whose headsign was derived from their last stop
+	FilledHeadsigns []string
+
+	// mapping of original to written trip IDs
+	TripIds map[string]string
+
+	// IDs of the trips whose wheelchair accessibility conflicts with their stops
+	WheelchairConflicts []string
+
+	// platform codes derived from stop names, by stop ID
+	PlatformCodes map[string]string
+
+	// timing and throughput of the written files, in order of writing
+	Files []*FileStats
+
+	// hex-encoded SHA-256 of the written ZIP file, if Checksums is set
+	ArchiveSha256 string
+
+	// total time spent in Write
+	Duration time.Duration
+
+	// entities altered by the writer, if RecordChanges or ChangeReportFile is set
+	Changes []Change
+
+	// completeness of the written feed, if ComputeQuality is set
+	Quality Quality
+
+	// duplicate keys found by the duplicate check, by file
+	Duplicates map[string][]string
+
+	// IDs of the trips whose stop times go backwards or repeat sequence numbers
+	InvalidStopTimes []string
+
+	// trips of the same block overlapping in time, and overlapping frequency windows
+	Conflicts []Conflict
+
+	// stops farther away from the shape of a trip serving them than MaxShapeDist
+	ShapeOutliers []ShapeOutlier
+}
+
+// CompressionRatio returns the compressed size of all written files
+// relative to their uncompressed size, 0 if they were not compressed
+func (r Report) CompressionRatio() float64 {
+	var bytes, compressed int64
+	for _, fs := range r.Files {
+		bytes += fs.Bytes
+		compressed += fs.CompressedBytes
+	}
+	if bytes == 0 {
+		return 0
+	}
+	return float64(compressed) / float64(bytes)
+}
+
+func newReport() Report {
+	return Report{
+		ZoneIds:        make(map[string]string),
+		ParentStations: make(map[string][]string),
+		MergedStops:    make(map[string]string),
+		MergedRoutes:   make(map[string]string),
+		TripIds:        make(map[string]string),
+		PlatformCodes:  make(map[string]string),
+		Duplicates:     make(map[string][]string),
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"errors"
+	"fmt"
+	"github.com/patrickbr/gtfsparser"
+	"syscall"
+	"time"
+)
+
+const defRetryBackoff = time.Second
+
+// writeRetrying writes a single table, and writes it again up to Retries
+// times if the output failed with a retryable error. Only files written
+// to a directory can be retried, a ZIP member cannot be rewritten.
+func (writer *Writer) writeRetrying(t table, path string, feed *gtfsparser.Feed) error {
+	backoff := writer.RetryBackoff
+	if backoff <= 0 {
+		backoff = defRetryBackoff
+	}
+
+	for attempt := 0; ; attempt++ {
+		// results of a failed attempt are discarded
+		report := writer.Report
+		warnings := len(writer.warnings)
+
+		writer.sinkErr = nil
+		e := t.write(path, feed)
+
+		if e == nil {
+			e = writer.closeFile()
+		} else {
+			writer.closeFile()
+		}
+
+		if e == nil || attempt >= writer.Retries || !writer.retryable(writer.sinkErr) {
+			return e
+		}
+
+		if fi, se := writer.backend().Stat(path); writer.single != nil || writer.extZip != nil || se != nil || !fi.IsDir() {
+			return e
+		}
+
+		writer.Report = report
+		writer.warnings = writer.warnings[:warnings]
+
+		writer.warn(t.name, "", "", fmt.Sprintf("retrying after error: %v", e))
+
+		if writer.ctx != nil {
+			select {
+			case <-writer.ctx.Done():
+				return e
+			case <-time.After(backoff):
+			}
+		} else {
+			time.Sleep(backoff)
+		}
+		backoff *= 2
+	}
+}
+
+// retryable checks whether an error of the output is transient
+func (writer *Writer) retryable(e error) bool {
+	if e == nil {
+		return false
+	}
+
+	if writer.RetryIf != nil {
+		return writer.RetryIf(e)
+	}
+
+	var timeout interface{ Timeout() bool }
+	if errors.As(e, &timeout) && timeout.Timeout() {
+		return true
+	}
+
+	return errors.Is(e, syscall.EAGAIN) || errors.Is(e, syscall.EINTR) || errors.Is(e, syscall.EBUSY) || errors.Is(e, syscall.ETIMEDOUT)
+}
@@ -0,0 +1,266 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+)
+
+// The row functions serialize a single entity exactly as Write does.
+// The package functions format the entity as it is, with the given
+// RowOptions. The methods of the same name use the settings of the
+// writer and also reflect its write-time transformations, like stop
+// merging, which are only known after a call to Write or WriteFile.
+// Rows hold one value per column returned by the matching columns
+// function, unused optional columns are not pruned and additional
+// fields are not included.
+
+// RowOptions are the settings of a Writer which affect how the row
+// functions format single entities
+type RowOptions struct {
+	ExplicitDefaults bool
+	KeepNewlines     bool
+	StripHtml        bool
+	SourceDistUnit   DistUnit
+	TargetDistUnit   DistUnit
+	DistDecimals     int
+}
+
+// rowOptions returns the RowOptions of the writer
+func (writer *Writer) rowOptions() RowOptions {
+	return RowOptions{
+		ExplicitDefaults: writer.ExplicitDefaults,
+		KeepNewlines:     writer.KeepNewlines,
+		StripHtml:        writer.StripHtml,
+		SourceDistUnit:   writer.SourceDistUnit,
+		TargetDistUnit:   writer.TargetDistUnit,
+		DistDecimals:     writer.DistDecimals,
+	}
+}
+
+// AgencyColumns returns the columns of rows returned by AgencyRow
+func AgencyColumns() []string {
+	return []string{"agency_id", "agency_name", "agency_url", "agency_timezone", "agency_lang", "agency_phone", "agency_fare_url", "agency_email"}
+}
+
+// StopColumns returns the columns of rows returned by StopRow
+func StopColumns() []string {
+	return []string{"stop_name", "parent_station", "stop_code", "zone_id", "stop_id", "stop_desc", "stop_lat", "stop_lon", "stop_url", "location_type", "stop_timezone", "wheelchair_boarding", "level_id", "platform_code"}
+}
+
+// RouteColumns returns the columns of rows returned by RouteRow
+func RouteColumns() []string {
+	return []string{"route_long_name", "route_short_name", "agency_id", "route_desc", "route_type", "route_id", "route_url", "route_color", "route_text_color", "route_sort_order", "continuous_pickup", "continuous_drop_off"}
+}
+
+// TripColumns returns the columns of rows returned by TripRow
+func TripColumns() []string {
+	return []string{"route_id", "service_id", "trip_headsign", "trip_short_name", "direction_id", "block_id", "shape_id", "trip_id", "wheelchair_accessible", "bikes_allowed"}
+}
+
+// StopTimeColumns returns the columns of rows returned by StopTimeRow
+func StopTimeColumns() []string {
+	return []string{"trip_id", "arrival_time", "departure_time", "stop_id", "stop_sequence", "stop_headsign", "pickup_type", "drop_off_type", "continuous_pickup", "continuous_drop_off", "shape_dist_traveled", "timepoint"}
+}
+
+// ShapePointColumns returns the columns of rows returned by ShapePointRow
+func ShapePointColumns() []string {
+	return []string{"shape_id", "shape_pt_lat", "shape_pt_lon", "shape_pt_sequence", "shape_dist_traveled"}
+}
+
+// CalendarColumns returns the columns of rows returned by CalendarRow
+func CalendarColumns() []string {
+	return []string{"monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday", "start_date", "end_date", "service_id"}
+}
+
+// AgencyRow returns the agency.txt row of an agency
+func AgencyRow(v *gtfs.Agency, o RowOptions) []string {
+	fareurl := ""
+	if v.Fare_url != nil {
+		fareurl = v.Fare_url.String()
+	}
+
+	url := ""
+	if v.Url != nil {
+		url = v.Url.String()
+	}
+
+	email := ""
+	if v.Email != nil {
+		email = v.Email.Address
+	}
+
+	return []string{v.Id, o.oneLine(v.Name), url, v.Timezone.GetTzString(), v.Lang.GetLangString(), v.Phone, fareurl, email}
+}
+
+// AgencyRow returns the agency.txt row of an agency
+func (writer *Writer) AgencyRow(v *gtfs.Agency) []string {
+	return AgencyRow(v, writer.rowOptions())
+}
+
+// StopRow returns the stops.txt row of a stop
+func StopRow(v *gtfs.Stop, o RowOptions) []string {
+	// dont print locType 0
+	locType := o.omitDefault(int(v.Location_type), 0)
+	wb := o.omitDefault(int(v.Wheelchair_boarding), 0)
+	parentStID := ""
+	if v.Parent_station != nil {
+		parentStID = v.Parent_station.Id
+	}
+	url := ""
+	if v.Url != nil {
+		url = v.Url.String()
+	}
+	levelId := ""
+	if v.Level != nil {
+		levelId = v.Level.Id
+	}
+	lat := ""
+	lon := ""
+	if v.HasLatLon() {
+		lat = formatFloat(v.Lat)
+		lon = formatFloat(v.Lon)
+	}
+
+	return []string{o.oneLine(v.Name), parentStID, v.Code, v.Zone_id, v.Id, o.oneLine(o.desc(v.Desc)), lat, lon, url, posIntToString(locType), v.Timezone.GetTzString(), posIntToString(wb), levelId, v.Platform_code}
+}
+
+// StopRow returns the stops.txt row of a stop as written
+func (writer *Writer) StopRow(v *gtfs.Stop) []string {
+	s := *v
+	s.Name = writer.stopName(v)
+	s.Parent_station = writer.parentStation(v)
+	s.Zone_id = writer.zoneId(v.Zone_id)
+	s.Platform_code = writer.platformCode(v)
+
+	return StopRow(&s, writer.rowOptions())
+}
+
+// RouteRow returns the routes.txt row of a route
+func RouteRow(r *gtfs.Route, o RowOptions) []string {
+	agency := ""
+	if r.Agency != nil {
+		agency = r.Agency.Id
+	}
+	color := r.Color
+	if color == "FFFFFF" && !o.ExplicitDefaults {
+		color = ""
+	}
+	textColor := r.Text_color
+	if textColor == "000000" && !o.ExplicitDefaults {
+		textColor = ""
+	}
+	url := ""
+	if r.Url != nil {
+		url = r.Url.String()
+	}
+	contPickup := o.omitDefault(int(r.Continuous_pickup), 1)
+	contDropOff := o.omitDefault(int(r.Continuous_drop_off), 1)
+
+	return []string{o.oneLine(r.Long_name), o.oneLine(r.Short_name), agency, o.oneLine(o.desc(r.Desc)), posIntToString(int(r.Type)), r.Id, url, color, textColor, posIntToString(r.Sort_order), posIntToString(contPickup), posIntToString(contDropOff)}
+}
+
+// RouteRow returns the routes.txt row of a route
+func (writer *Writer) RouteRow(r *gtfs.Route) []string {
+	return RouteRow(r, writer.rowOptions())
+}
+
+// TripRow returns the trips.txt row of a trip
+func TripRow(t *gtfs.Trip, o RowOptions) []string {
+	wa := o.omitDefault(int(t.Wheelchair_accessible), 0)
+	ba := o.omitDefault(int(t.Bikes_allowed), 0)
+	headsign := ""
+	if t.Headsign != nil {
+		headsign = *t.Headsign
+	}
+	shortname := ""
+	if t.Short_name != nil {
+		shortname = *t.Short_name
+	}
+	blockId := ""
+	if t.Block_id != nil {
+		blockId = *t.Block_id
+	}
+	shapeId := ""
+	if t.Shape != nil {
+		shapeId = t.Shape.Id
+	}
+
+	return []string{t.Route.Id, t.Service.Id(), o.oneLine(headsign), o.oneLine(shortname), posIntToString(int(t.Direction_id)), blockId, shapeId, t.Id, posIntToString(wa), posIntToString(ba)}
+}
+
+// TripRow returns the trips.txt row of a trip as written
+func (writer *Writer) TripRow(t *gtfs.Trip) []string {
+	headsign := writer.headsign(t)
+	blockId := writer.blockId(t)
+
+	c := *t
+	c.Route = writer.route(t.Route)
+	c.Headsign = &headsign
+	c.Block_id = &blockId
+	c.Shape = writer.tripShape(t)
+	c.Id = writer.tripId(t)
+	c.Wheelchair_accessible = writer.wheelchairAccessible(t)
+	c.Bikes_allowed = writer.bikesAllowed(t)
+
+	return TripRow(&c, writer.rowOptions())
+}
+
+// StopTimeRow returns the stop_times.txt row of a stop time of trip t
+func StopTimeRow(t *gtfs.Trip, st *gtfs.StopTime, o RowOptions) []string {
+	stopId := ""
+	if st.Stop() != nil {
+		stopId = st.Stop().Id
+	}
+
+	row := make([]string, 12)
+	stopTimeLine(t.Id, stopId, st, row, o)
+	return row
+}
+
+// StopTimeRow returns the stop_times.txt row of a stop time of trip t
+// as written
+func (writer *Writer) StopTimeRow(t *gtfs.Trip, st *gtfs.StopTime) []string {
+	row := make([]string, 12)
+	writer.stopTimeLine(t, st, row)
+	return row
+}
+
+// ShapePointRow returns the shapes.txt row of a point of shape s
+func ShapePointRow(s *gtfs.Shape, p *gtfs.ShapePoint, o RowOptions) []string {
+	row := make([]string, 5)
+	shapePointLine(s, p, row, o)
+	return row
+}
+
+// ShapePointRow returns the shapes.txt row of a point of shape s
+func (writer *Writer) ShapePointRow(s *gtfs.Shape, p *gtfs.ShapePoint) []string {
+	return ShapePointRow(s, p, writer.rowOptions())
+}
+
+// CalendarRow returns the calendar.txt row of the weekly pattern of a
+// service
+func CalendarRow(v *gtfs.Service) []string {
+	return []string{boolToGtfsBool(v.Daymap(1), true), boolToGtfsBool(v.Daymap(2), true), boolToGtfsBool(v.Daymap(3), true), boolToGtfsBool(v.Daymap(4), true), boolToGtfsBool(v.Daymap(5), true), boolToGtfsBool(v.Daymap(6), true), boolToGtfsBool(v.Daymap(0), true), dateToString(v.Start_date()), dateToString(v.End_date()), v.Id()}
+}
+
+// CalendarRow returns the calendar.txt row of the weekly pattern of a
+// service
+func (writer *Writer) CalendarRow(v *gtfs.Service) []string {
+	return CalendarRow(v)
+}
+
+// FormatTime formats a time as HH:MM:SS, as written to stop_times.txt
+func FormatTime(t gtfs.Time) string {
+	return timeToString(t)
+}
+
+// FormatDate formats a date as YYYYMMDD, an empty date as an empty
+// string
+func FormatDate(d gtfs.Date) string {
+	return dateToString(d)
+}
@@ -0,0 +1,115 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// GTFS field types of the columns the writer produces, additional
+// columns are typed as text
+var columnTypes = map[string]string{
+	"agency_id": "id", "agency_name": "text", "agency_url": "url", "agency_timezone": "timezone",
+	"agency_lang": "language_code", "agency_phone": "phone_number", "agency_fare_url": "url", "agency_email": "email",
+
+	"feed_publisher_name": "text", "feed_publisher_url": "url", "feed_lang": "language_code",
+	"feed_start_date": "date", "feed_end_date": "date", "feed_version": "text",
+	"feed_contact_email": "email", "feed_contact_url": "url",
+
+	"stop_id": "id", "stop_code": "text", "stop_name": "text", "stop_desc": "text",
+	"stop_lat": "latitude", "stop_lon": "longitude", "zone_id": "id", "stop_url": "url",
+	"location_type": "enum", "parent_station": "id", "stop_timezone": "timezone",
+	"wheelchair_boarding": "enum", "level_id": "id", "platform_code": "text",
+
+	"route_id": "id", "route_short_name": "text", "route_long_name": "text", "route_desc": "text",
+	"route_type": "enum", "route_url": "url", "route_color": "color", "route_text_color": "color",
+	"route_sort_order": "non_negative_integer", "continuous_pickup": "enum", "continuous_drop_off": "enum",
+
+	"service_id": "id", "monday": "enum", "tuesday": "enum", "wednesday": "enum", "thursday": "enum",
+	"friday": "enum", "saturday": "enum", "sunday": "enum", "start_date": "date", "end_date": "date",
+	"date": "date", "exception_type": "enum",
+
+	"trip_id": "id", "trip_headsign": "text", "trip_short_name": "text", "direction_id": "enum",
+	"block_id": "id", "shape_id": "id", "wheelchair_accessible": "enum", "bikes_allowed": "enum",
+
+	"arrival_time": "time", "departure_time": "time", "stop_sequence": "non_negative_integer",
+	"stop_headsign": "text", "pickup_type": "enum", "drop_off_type": "enum",
+	"shape_dist_traveled": "non_negative_float", "timepoint": "enum",
+	"arrival_secs": "non_negative_integer", "departure_secs": "non_negative_integer",
+
+	"shape_pt_lat": "latitude", "shape_pt_lon": "longitude", "shape_pt_sequence": "non_negative_integer",
+
+	"fare_id": "id", "price": "non_negative_float", "currency_type": "currency_code",
+	"payment_method": "enum", "transfers": "enum", "transfer_duration": "non_negative_integer",
+	"origin_id": "id", "destination_id": "id", "contains_id": "id",
+
+	"start_time": "time", "end_time": "time", "headway_secs": "non_negative_integer", "exact_times": "enum",
+
+	"from_stop_id": "id", "to_stop_id": "id", "from_route_id": "id", "to_route_id": "id",
+	"from_trip_id": "id", "to_trip_id": "id", "transfer_type": "enum", "min_transfer_time": "non_negative_integer",
+
+	"level_index": "float", "level_name": "text",
+
+	"pathway_id": "id", "pathway_mode": "enum", "is_bidirectional": "enum", "length": "non_negative_float",
+	"traversal_time": "non_negative_integer", "stair_count": "integer", "max_slope": "float",
+	"min_width": "non_negative_float", "signposted_as": "text", "reversed_signposted_as": "text",
+
+	"attribution_id": "id", "organization_name": "text", "is_producer": "enum", "is_operator": "enum",
+	"is_authority": "enum", "attribution_url": "url", "attribution_email": "email", "attribution_phone": "phone_number",
+}
+
+// A ColumnSchema describes a single written column
+type ColumnSchema struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// A FileSchema describes the columns of a written file, in order
+type FileSchema struct {
+	Name    string         `json:"name"`
+	Columns []ColumnSchema `json:"columns"`
+}
+
+// Schema returns the effective schema of the files written
+func (r Report) Schema() []FileSchema {
+	ret := make([]FileSchema, 0, len(r.Files))
+
+	for _, fs := range r.Files {
+		cols := make([]ColumnSchema, len(fs.Columns))
+		for i, c := range fs.Columns {
+			t, ok := columnTypes[c]
+			if !ok {
+				t = "text"
+			}
+			cols[i] = ColumnSchema{c, t}
+		}
+		ret = append(ret, FileSchema{fs.Name, cols})
+	}
+
+	return ret
+}
+
+// writeSchema writes the effective schema of the written files
+// to SchemaFile as JSON
+func (writer *Writer) writeSchema() error {
+	if len(writer.SchemaFile) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+
+	enc := json.NewEncoder(&buf)
+	enc.SetIndent("", "  ")
+	if e := enc.Encode(struct {
+		Files []FileSchema `json:"files"`
+	}{writer.Report.Schema()}); e != nil {
+		return writeError{writer.SchemaFile, e.Error()}
+	}
+
+	return writer.writeSidecar(writer.SchemaFile, buf.Bytes())
+}
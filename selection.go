@@ -0,0 +1,58 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+// selectedTables returns the tables to be written, according to
+// OnlyFiles and SkipFiles. The files of SortKeys are checked as well.
+func (writer *Writer) selectedTables(attributions entAttrs) ([]table, error) {
+	tables := writer.tables(attributions)
+
+	if e := writer.checkExtraTables(tables[:len(tables)-len(writer.extraTables)]); e != nil {
+		return nil, e
+	}
+
+	if len(writer.OnlyFiles) == 0 && len(writer.SkipFiles) == 0 && len(writer.SortKeys) == 0 {
+		return tables, nil
+	}
+
+	known := make(map[string]bool, len(tables))
+	for _, t := range tables {
+		known[t.name] = true
+	}
+
+	for name := range writer.SortKeys {
+		if !known[name] {
+			return nil, writeError{name, "unknown GTFS file"}
+		}
+	}
+
+	only := make(map[string]bool, len(writer.OnlyFiles))
+	for _, name := range writer.OnlyFiles {
+		if !known[name] {
+			return nil, writeError{name, "unknown GTFS file"}
+		}
+		only[name] = true
+	}
+
+	skip := make(map[string]bool, len(writer.SkipFiles))
+	for _, name := range writer.SkipFiles {
+		if !known[name] {
+			return nil, writeError{name, "unknown GTFS file"}
+		}
+		skip[name] = true
+	}
+
+	ret := make([]table, 0, len(tables))
+	for _, t := range tables {
+		if (len(only) > 0 && !only[t.name]) || skip[t.name] {
+			continue
+		}
+		ret = append(ret, t)
+	}
+
+	return ret, nil
+}
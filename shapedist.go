@@ -0,0 +1,125 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"fmt"
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"math"
+	"sort"
+	"strings"
+)
+
+const defMaxShapeDist = 100.0
+
+// A ShapeOutlier is a stop served by a trip which is farther away
+// from the trip's shape than MaxShapeDist
+type ShapeOutlier struct {
+	TripId  string  `json:"trip_id"`
+	ShapeId string  `json:"shape_id"`
+	StopId  string  `json:"stop_id"`
+	Dist    float64 `json:"dist"`
+}
+
+type shapeOutliers []ShapeOutlier
+
+func (so shapeOutliers) Len() int      { return len(so) }
+func (so shapeOutliers) Swap(i, j int) { so[i], so[j] = so[j], so[i] }
+func (so shapeOutliers) Less(i, j int) bool {
+	if so[i].TripId != so[j].TripId {
+		return so[i].TripId < so[j].TripId
+	}
+	return so[i].StopId < so[j].StopId
+}
+
+type shapeStop struct {
+	shape *gtfs.Shape
+	stop  *gtfs.Stop
+}
+
+// checkShapeDists computes the distance of each stop served by a trip
+// to the trip's shape, and reports stops farther away than
+// MaxShapeDist according to the ShapeDistCheck policy
+func (writer *Writer) checkShapeDists(feed *gtfsparser.Feed) error {
+	if writer.ShapeDistCheck == CheckOff {
+		return nil
+	}
+
+	maxDist := writer.MaxShapeDist
+	if maxDist <= 0 {
+		maxDist = defMaxShapeDist
+	}
+
+	// distances are cached, as many trips share shapes and stops
+	dists := make(map[shapeStop]float64)
+	found := make(shapeOutliers, 0)
+
+	for _, t := range feed.Trips {
+		shp := writer.tripShape(t)
+		if shp == nil || len(shp.Points) == 0 {
+			continue
+		}
+
+		for i := range t.StopTimes {
+			s := writer.stop(t.StopTimes[i].Stop())
+			if s == nil || !s.HasLatLon() {
+				continue
+			}
+
+			d, ok := dists[shapeStop{shp, s}]
+			if !ok {
+				d = shapeDist(shp, s)
+				dists[shapeStop{shp, s}] = d
+			}
+
+			if d > maxDist {
+				found = append(found, ShapeOutlier{writer.tripId(t), shp.Id, s.Id, d})
+			}
+		}
+	}
+
+	sort.Sort(found)
+	writer.Report.ShapeOutliers = found
+
+	if len(found) == 0 {
+		return nil
+	}
+
+	if writer.ShapeDistCheck == CheckFail {
+		list := make([]string, len(found))
+		for i, o := range found {
+			list[i] = fmt.Sprintf("stop '%s' of trip '%s' (%.0f m)", o.StopId, o.TripId, o.Dist)
+		}
+		return writeError{"shapes.txt", "stops far from their shape: " + strings.Join(list, ", ")}
+	}
+
+	for _, o := range found {
+		writer.warn("stop_times.txt", o.TripId, "stop_id", fmt.Sprintf("stop '%s' is %.0f m away from shape '%s'", o.StopId, o.Dist, o.ShapeId))
+	}
+
+	return nil
+}
+
+// shapeDist returns the distance in meters between a stop and a shape
+func shapeDist(shp *gtfs.Shape, s *gtfs.Stop) float64 {
+	lat := float64(s.Lat)
+	lon := float64(s.Lon)
+
+	if len(shp.Points) == 1 {
+		return haversineDist(lat, lon, float64(shp.Points[0].Lat), float64(shp.Points[0].Lon))
+	}
+
+	ret := math.Inf(1)
+	for i := 1; i < len(shp.Points); i++ {
+		a := shp.Points[i-1]
+		b := shp.Points[i]
+		ret = math.Min(ret, segmentDist(lat, lon, float64(a.Lat), float64(a.Lon), float64(b.Lat), float64(b.Lon)))
+	}
+
+	return ret
+}
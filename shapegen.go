@@ -0,0 +1,98 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// generateShapes synthesizes straight-line shapes (stop to stop) for
+// all trips without a shape. Trips serving the same stop sequence share
+// a single shape. The feed itself is not modified.
+func (writer *Writer) generateShapes(feed *gtfsparser.Feed) {
+	writer.tripShapes = make(map[*gtfs.Trip]*gtfs.Shape)
+	writer.genShapes = nil
+
+	if !writer.GenerateShapes {
+		return
+	}
+
+	// process trips ordered by ID to get deterministic shape IDs
+	tripIds := make([]string, 0)
+	for id, t := range feed.Trips {
+		if t.Shape == nil {
+			tripIds = append(tripIds, id)
+		}
+	}
+	sort.Strings(tripIds)
+
+	usedIds := make(map[string]bool, len(feed.Shapes))
+	for id := range feed.Shapes {
+		usedIds[id] = true
+	}
+
+	patterns := make(map[string]*gtfs.Shape)
+
+	for _, id := range tripIds {
+		t := feed.Trips[id]
+		points := make(gtfs.ShapePoints, 0, len(t.StopTimes))
+		stopIds := make([]string, 0, len(t.StopTimes))
+
+		for i := range t.StopTimes {
+			s := writer.stop(t.StopTimes[i].Stop())
+			if s == nil || !s.HasLatLon() {
+				continue
+			}
+			stopIds = append(stopIds, s.Id)
+			points = append(points, gtfs.ShapePoint{
+				Lat:           s.Lat,
+				Lon:           s.Lon,
+				Sequence:      uint32(len(points) + 1),
+				Dist_traveled: float32(math.NaN()),
+			})
+		}
+
+		if len(points) < 2 {
+			continue
+		}
+
+		key := strings.Join(stopIds, "\x00")
+
+		if shp, ok := patterns[key]; ok {
+			writer.tripShapes[t] = shp
+			writer.change("trips.txt", t.Id, "shape_id", ChangeFilled, "", shp.Id)
+			continue
+		}
+
+		shpId := "shp_" + t.Id
+		for i := 2; usedIds[shpId]; i++ {
+			shpId = "shp_" + t.Id + "_" + strconv.Itoa(i)
+		}
+		usedIds[shpId] = true
+
+		shp := &gtfs.Shape{Id: shpId, Points: points}
+		patterns[key] = shp
+		writer.tripShapes[t] = shp
+		writer.genShapes = append(writer.genShapes, shp)
+		writer.change("shapes.txt", shpId, "", ChangeCreated, "", "")
+		writer.change("trips.txt", t.Id, "shape_id", ChangeFilled, "", shpId)
+	}
+}
+
+// tripShape returns the shape written for a trip, which is either the
+// trip's own shape or a generated one
+func (writer *Writer) tripShape(t *gtfs.Trip) *gtfs.Shape {
+	if t.Shape != nil {
+		return t.Shape
+	}
+	return writer.tripShapes[t]
+}
@@ -0,0 +1,25 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+// sortLines sorts the buffered lines of file by its SortKeys, if
+// configured, or otherwise by their first depth columns if Sorted is
+// set. An unknown sort column is reported by the following Flush.
+func (writer *Writer) sortLines(csvwriter *CsvWriter, file string, depth int) {
+	if keys, ok := writer.SortKeys[file]; ok {
+		csvwriter.SortByKeys(keys)
+	} else if writer.Sorted && depth > 0 {
+		csvwriter.SortByCols(depth)
+	}
+}
+
+// keyed checks whether file has SortKeys. Files written trip by trip
+// or shape by shape are then buffered and sorted as a whole.
+func (writer *Writer) keyed(file string) bool {
+	_, ok := writer.SortKeys[file]
+	return ok
+}
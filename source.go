@@ -0,0 +1,192 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"io"
+)
+
+// A FeedSource provides the entities of a feed to the writer, by
+// calling the given function once for each entity. This allows
+// writing feeds held in other in-memory models, which only have to
+// provide their entities as gtfs types instead of building a complete
+// Feed with its ID indices. The writer still indexes them in a Feed
+// held in memory; use a Builder to stream stop times instead. Trips are
+// expected to carry their stop times and frequencies, fare attributes
+// their rules.
+type FeedSource interface {
+	Agencies(func(*gtfs.Agency))
+	Stops(func(*gtfs.Stop))
+	Routes(func(*gtfs.Route))
+	Trips(func(*gtfs.Trip))
+	Services(func(*gtfs.Service))
+	FareAttributes(func(*gtfs.FareAttribute))
+	Shapes(func(*gtfs.Shape))
+	Levels(func(*gtfs.Level))
+	Pathways(func(*gtfs.Pathway))
+	Transfers(func(gtfs.TransferKey, gtfs.TransferVal))
+	FeedInfos(func(*gtfs.FeedInfo))
+	Attributions(func(*gtfs.Attribution))
+}
+
+// A FeedAdapter provides a gtfsparser.Feed as a FeedSource
+type FeedAdapter struct {
+	Feed *gtfsparser.Feed
+}
+
+// Agencies calls fn for each agency of the feed
+func (fa FeedAdapter) Agencies(fn func(*gtfs.Agency)) {
+	for _, v := range fa.Feed.Agencies {
+		fn(v)
+	}
+}
+
+// Stops calls fn for each stop of the feed
+func (fa FeedAdapter) Stops(fn func(*gtfs.Stop)) {
+	for _, v := range fa.Feed.Stops {
+		fn(v)
+	}
+}
+
+// Routes calls fn for each route of the feed
+func (fa FeedAdapter) Routes(fn func(*gtfs.Route)) {
+	for _, v := range fa.Feed.Routes {
+		fn(v)
+	}
+}
+
+// Trips calls fn for each trip of the feed
+func (fa FeedAdapter) Trips(fn func(*gtfs.Trip)) {
+	for _, v := range fa.Feed.Trips {
+		fn(v)
+	}
+}
+
+// Services calls fn for each service of the feed
+func (fa FeedAdapter) Services(fn func(*gtfs.Service)) {
+	for _, v := range fa.Feed.Services {
+		fn(v)
+	}
+}
+
+// FareAttributes calls fn for each fare attribute of the feed
+func (fa FeedAdapter) FareAttributes(fn func(*gtfs.FareAttribute)) {
+	for _, v := range fa.Feed.FareAttributes {
+		fn(v)
+	}
+}
+
+// Shapes calls fn for each shape of the feed
+func (fa FeedAdapter) Shapes(fn func(*gtfs.Shape)) {
+	for _, v := range fa.Feed.Shapes {
+		fn(v)
+	}
+}
+
+// Levels calls fn for each level of the feed
+func (fa FeedAdapter) Levels(fn func(*gtfs.Level)) {
+	for _, v := range fa.Feed.Levels {
+		fn(v)
+	}
+}
+
+// Pathways calls fn for each pathway of the feed
+func (fa FeedAdapter) Pathways(fn func(*gtfs.Pathway)) {
+	for _, v := range fa.Feed.Pathways {
+		fn(v)
+	}
+}
+
+// Transfers calls fn for each transfer of the feed
+func (fa FeedAdapter) Transfers(fn func(gtfs.TransferKey, gtfs.TransferVal)) {
+	for k, v := range fa.Feed.Transfers {
+		fn(k, v)
+	}
+}
+
+// FeedInfos calls fn for each feed info of the feed
+func (fa FeedAdapter) FeedInfos(fn func(*gtfs.FeedInfo)) {
+	for _, v := range fa.Feed.FeedInfos {
+		fn(v)
+	}
+}
+
+// Attributions calls fn for each feed-level attribution of the feed
+func (fa FeedAdapter) Attributions(fn func(*gtfs.Attribution)) {
+	for _, v := range fa.Feed.Attributions {
+		fn(v)
+	}
+}
+
+// WriteSource writes the feed provided by src to path, like Write. The
+// entities of src are indexed in a Feed held in memory, which is then
+// written; the entities themselves are not copied. If src is a
+// FeedAdapter, the underlying feed is written directly, including its
+// additional fields and column orders.
+func (writer *Writer) WriteSource(src FeedSource, path string) error {
+	return writer.Write(sourceFeed(src), path)
+}
+
+// WriteSourceFile writes a single GTFS file of the feed provided by src
+// to out, like WriteFile
+func (writer *Writer) WriteSourceFile(src FeedSource, name string, out io.Writer) error {
+	return writer.WriteFile(sourceFeed(src), name, out)
+}
+
+// WriteSourceToMemory writes the feed provided by src like
+// WriteToMemory and returns the written files by name
+func (writer *Writer) WriteSourceToMemory(src FeedSource) (map[string][]byte, error) {
+	return writer.WriteToMemory(sourceFeed(src))
+}
+
+// WriteSourceToStream writes the feed provided by src as a ZIP file to
+// out, like WriteToStream
+func (writer *Writer) WriteSourceToStream(src FeedSource, out io.Writer) error {
+	return writer.WriteToStream(sourceFeed(src), out)
+}
+
+// sourceFeed returns the feed of a FeedAdapter, or indexes the entities
+// of any other FeedSource in a new Feed
+func sourceFeed(src FeedSource) *gtfsparser.Feed {
+	if fa, ok := src.(FeedAdapter); ok {
+		return fa.Feed
+	}
+	if fa, ok := src.(*FeedAdapter); ok {
+		return fa.Feed
+	}
+
+	return feedFromSource(src)
+}
+
+// feedFromSource indexes the entities of a FeedSource by their IDs.
+// The entities themselves are not copied.
+func feedFromSource(src FeedSource) *gtfsparser.Feed {
+	feed := gtfsparser.NewFeed()
+
+	src.Agencies(func(v *gtfs.Agency) { feed.Agencies[v.Id] = v })
+	src.Stops(func(v *gtfs.Stop) { feed.Stops[v.Id] = v })
+	src.Routes(func(v *gtfs.Route) { feed.Routes[v.Id] = v })
+	src.Trips(func(v *gtfs.Trip) {
+		feed.Trips[v.Id] = v
+		feed.NumStopTimes += len(v.StopTimes)
+	})
+	src.Services(func(v *gtfs.Service) { feed.Services[v.Id()] = v })
+	src.FareAttributes(func(v *gtfs.FareAttribute) { feed.FareAttributes[v.Id] = v })
+	src.Shapes(func(v *gtfs.Shape) {
+		feed.Shapes[v.Id] = v
+		feed.NumShpPoints += len(v.Points)
+	})
+	src.Levels(func(v *gtfs.Level) { feed.Levels[v.Id] = v })
+	src.Pathways(func(v *gtfs.Pathway) { feed.Pathways[v.Id] = v })
+	src.Transfers(func(k gtfs.TransferKey, v gtfs.TransferVal) { feed.Transfers[k] = v })
+	src.FeedInfos(func(v *gtfs.FeedInfo) { feed.FeedInfos = append(feed.FeedInfos, v) })
+	src.Attributions(func(v *gtfs.Attribution) { feed.Attributions = append(feed.Attributions, v) })
+
+	return feed
+}
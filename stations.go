@@ -0,0 +1,142 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"sort"
+	"strconv"
+)
+
+// default maximum distance in meters between platforms clustered
+// into a single station
+const defParentStationDist = 100.0
+
+type stopsById []*gtfs.Stop
+
+func (s stopsById) Len() int           { return len(s) }
+func (s stopsById) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s stopsById) Less(i, j int) bool { return s[i].Id < s[j].Id }
+
+// generateParentStations clusters nearby platforms with identical
+// names and without a parent station into generated stations. The
+// feed itself is not modified.
+func (writer *Writer) generateParentStations(feed *gtfsparser.Feed) {
+	writer.parents = make(map[*gtfs.Stop]*gtfs.Stop)
+	writer.genStops = nil
+
+	if !writer.GenerateParentStations {
+		return
+	}
+
+	maxDist := writer.ParentStationDist
+	if maxDist <= 0 {
+		maxDist = defParentStationDist
+	}
+
+	byName := make(map[string]stopsById)
+	for _, s := range feed.Stops {
+		if s.Location_type != 0 || s.Parent_station != nil || !s.HasLatLon() || writer.excl.stops[s] || writer.merged(s) {
+			continue
+		}
+		byName[s.Name] = append(byName[s.Name], s)
+	}
+
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		stops := byName[name]
+		if len(stops) < 2 {
+			continue
+		}
+		sort.Sort(stops)
+
+		for _, cluster := range clusterStops(stops, maxDist) {
+			if len(cluster) < 2 {
+				continue
+			}
+
+			id := "par_" + cluster[0].Id
+			for i := 2; feed.Stops[id] != nil; i++ {
+				id = "par_" + cluster[0].Id + "_" + strconv.Itoa(i)
+			}
+
+			lat := 0.0
+			lon := 0.0
+			members := make([]string, len(cluster))
+			for i, s := range cluster {
+				lat += float64(s.Lat)
+				lon += float64(s.Lon)
+				members[i] = s.Id
+			}
+
+			station := &gtfs.Stop{
+				Id:            id,
+				Name:          name,
+				Lat:           float32(lat / float64(len(cluster))),
+				Lon:           float32(lon / float64(len(cluster))),
+				Location_type: 1,
+				Timezone:      cluster[0].Timezone,
+			}
+
+			for _, s := range cluster {
+				writer.parents[s] = station
+				writer.change("stops.txt", s.Id, "parent_station", ChangeFilled, "", id)
+			}
+			writer.change("stops.txt", id, "", ChangeCreated, "", "")
+
+			writer.genStops = append(writer.genStops, station)
+			writer.Report.ParentStations[id] = members
+		}
+	}
+}
+
+// clusterStops groups stops into clusters where each stop is at most
+// maxDist meters away from some other stop of the same cluster
+func clusterStops(stops []*gtfs.Stop, maxDist float64) [][]*gtfs.Stop {
+	clusters := make([][]*gtfs.Stop, 0)
+	assigned := make([]bool, len(stops))
+
+	for i := range stops {
+		if assigned[i] {
+			continue
+		}
+
+		assigned[i] = true
+		cluster := []*gtfs.Stop{stops[i]}
+
+		for j := 0; j < len(cluster); j++ {
+			for k := range stops {
+				if assigned[k] {
+					continue
+				}
+				if haversineDist(float64(cluster[j].Lat), float64(cluster[j].Lon), float64(stops[k].Lat), float64(stops[k].Lon)) <= maxDist {
+					assigned[k] = true
+					cluster = append(cluster, stops[k])
+				}
+			}
+		}
+
+		sort.Sort(stopsById(cluster))
+		clusters = append(clusters, cluster)
+	}
+
+	return clusters
+}
+
+// parentStation returns the parent station written for a stop
+func (writer *Writer) parentStation(s *gtfs.Stop) *gtfs.Stop {
+	if s.Parent_station != nil {
+		return writer.stop(s.Parent_station)
+	}
+	return writer.parents[s]
+}
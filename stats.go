@@ -0,0 +1,55 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	"time"
+)
+
+// Stats summarizes the files written by a call to Write, e.g. for
+// monitoring automated feed publishing
+type Stats struct {
+	// statistics of the written files, in order of writing
+	Files []FileStats
+
+	// number of rows written to all files, without headers
+	Rows int
+
+	// number of uncompressed and compressed bytes written
+	Bytes           int64
+	CompressedBytes int64
+
+	// number of optional columns not written because they are unused
+	PrunedColumns int
+
+	// total time spent in Write
+	Duration time.Duration
+}
+
+// Stats returns the statistics of the written files
+func (r Report) Stats() Stats {
+	ret := Stats{Files: make([]FileStats, 0, len(r.Files)), Duration: r.Duration}
+
+	for _, fs := range r.Files {
+		ret.Files = append(ret.Files, *fs)
+		ret.Rows += fs.Rows
+		ret.Bytes += fs.Bytes
+		ret.CompressedBytes += fs.CompressedBytes
+		ret.PrunedColumns += len(fs.PrunedColumns)
+	}
+
+	return ret
+}
+
+// WriteWithStats writes a feed like Write, and returns the statistics
+// of the written files. They are also returned if writing failed, and
+// then cover the files written so far.
+func (writer *Writer) WriteWithStats(feed *gtfsparser.Feed, path string) (Stats, error) {
+	e := writer.Write(feed, path)
+	return writer.Report.Stats(), e
+}
@@ -0,0 +1,48 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"fmt"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+)
+
+// stopTimesProblem checks whether the times of a trip go backwards
+// or its sequence numbers repeat. It returns the affected field and a
+// description of the first problem found, or empty strings.
+func stopTimesProblem(t *gtfs.Trip) (string, string) {
+	last := -1
+	lastSeq := 0
+
+	for i := range t.StopTimes {
+		st := &t.StopTimes[i]
+
+		if i > 0 && st.Sequence() == lastSeq {
+			return "stop_sequence", fmt.Sprintf("stop_sequence %d repeats", st.Sequence())
+		}
+		lastSeq = st.Sequence()
+
+		arr := st.Arrival_time()
+		dep := st.Departure_time()
+
+		if !arr.Empty() {
+			if timeToSeconds(arr) < last {
+				return "arrival_time", fmt.Sprintf("arrival_time %s at stop_sequence %d is before the previous departure", timeToString(arr), st.Sequence())
+			}
+			last = timeToSeconds(arr)
+		}
+
+		if !dep.Empty() {
+			if timeToSeconds(dep) < last {
+				return "departure_time", fmt.Sprintf("departure_time %s at stop_sequence %d is before the arrival", timeToString(dep), st.Sequence())
+			}
+			last = timeToSeconds(dep)
+		}
+	}
+
+	return "", ""
+}
@@ -0,0 +1,173 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"errors"
+	"fmt"
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"sort"
+	"strings"
+)
+
+// writeStopTimesStreaming writes stop_times.txt in a single pass over
+// the stop times. As column usage cannot be collected beforehand, the
+// columns of the input feed are written, or all columns if they are
+// unknown. Values in other columns are dropped with a warning.
+func (writer *Writer) writeStopTimesStreaming(path string, feed *gtfsparser.Feed) (err error) {
+	if writer.PartialFeed == PartialSkip && !hasStopTimes(feed) {
+		return writer.delExistingFile(path, "stop_times.txt")
+	}
+
+	// sorting by keys requires buffering all rows
+	if writer.keyed("stop_times.txt") {
+		return writeError{"stop_times.txt", "SortKeys cannot be used with StreamStopTimes"}
+	}
+
+	file, e := writer.getFileForWriting(path, "stop_times.txt")
+
+	if e != nil {
+		return errors.New("Could not open required file stop_times.txt for writing")
+	}
+
+	csvwriter := writer.newCsvWriter(file, "stop_times.txt")
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = writer.recovered("stop_times.txt", r)
+		}
+	}()
+
+	header := StopTimeColumns()
+	required := []string{"trip_id", "arrival_time", "departure_time", "stop_id", "stop_sequence"}
+
+	addFieldsOrder := make([]string, 0)
+
+	for k := range feed.StopTimesAddFlds {
+		header = append(header, k)
+		addFieldsOrder = append(addFieldsOrder, k)
+	}
+
+	secs := len(header)
+	if writer.SecondsColumns {
+		header = append(header, "arrival_secs", "departure_secs")
+	}
+
+	csvwriter.SetHeader(header, required)
+
+	if writer.KeepColOrder {
+		csvwriter.SetOrder(feed.ColOrders.StopTimes)
+	}
+
+	// column usage from the columns of the input, additional fields
+	// and seconds columns are always written, and so are the columns
+	// with default values if ExplicitDefaults is set
+	known := make(map[string]bool)
+	for _, name := range append(feed.ColOrders.StopTimes, required...) {
+		known[name] = true
+	}
+	if writer.ExplicitDefaults {
+		for _, name := range []string{"pickup_type", "drop_off_type", "continuous_pickup", "continuous_drop_off", "timepoint"} {
+			known[name] = true
+		}
+	}
+
+	used := make([]bool, len(header))
+	usage := make([]string, len(header))
+	for i, name := range header {
+		if len(feed.ColOrders.StopTimes) == 0 || known[name] || i >= 12 || writer.KeepAllColumns {
+			used[i] = true
+			usage[i] = "-"
+		}
+	}
+	csvwriter.HeaderUsage(usage)
+
+	csvwriter.WriteHeader()
+
+	row := make([]string, len(header))
+	invalid := make([]string, 0)
+	dropped := make(map[string]bool)
+
+	writeTrip := func(n int, v *gtfs.Trip) error {
+		if ce := writer.checkCanceled("stop_times.txt", n); ce != nil {
+			return ce
+		}
+
+		if writer.StopTimesCheck != CheckOff {
+			if field, msg := stopTimesProblem(v); len(msg) > 0 {
+				writer.Report.InvalidStopTimes = append(writer.Report.InvalidStopTimes, writer.tripId(v))
+				if writer.StopTimesCheck == CheckWarn {
+					writer.warn("stop_times.txt", writer.tripId(v), field, msg)
+				} else {
+					invalid = append(invalid, fmt.Sprintf("'%s' (%s)", writer.tripId(v), msg))
+				}
+			}
+		}
+
+		for _, st := range v.StopTimes {
+			writer.stopTimeLine(v, &st, row)
+
+			for i, name := range addFieldsOrder {
+				if vald, ok := feed.StopTimesAddFlds[name][v.Id][st.Sequence()]; ok {
+					row[12+i] = vald
+				} else {
+					row[12+i] = ""
+				}
+			}
+
+			if writer.SecondsColumns {
+				stopTimeSeconds(&st, row[secs:])
+			}
+
+			for i, val := range row {
+				if len(val) > 0 && !used[i] && !dropped[header[i]] {
+					dropped[header[i]] = true
+					writer.warn("stop_times.txt", writer.tripId(v), header[i], "column not in the input, values dropped")
+				}
+			}
+
+			csvwriter.WriteCsvLineRaw(row)
+		}
+
+		return nil
+	}
+
+	if writer.Sorted {
+		lines := make(tripLines, 0, len(feed.Trips))
+		for _, v := range feed.Trips {
+			lines = append(lines, tripLine{v})
+		}
+		writer.sortTrips(lines)
+		for n, l := range lines {
+			if we := writeTrip(n, l.Trip); we != nil {
+				return we
+			}
+		}
+	} else {
+		n := 0
+		for _, v := range feed.Trips {
+			if we := writeTrip(n, v); we != nil {
+				return we
+			}
+			n++
+		}
+	}
+
+	sort.Strings(writer.Report.InvalidStopTimes)
+
+	if len(invalid) > 0 {
+		sort.Strings(invalid)
+		return writeError{"stop_times.txt", "trips with invalid stop times: " + strings.Join(invalid, ", ")}
+	}
+
+	if fe := csvwriter.FlushFile(); fe != nil {
+		return writeError{"stop_times.txt", fe.Error()}
+	}
+
+	return e
+}
@@ -0,0 +1,54 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+var htmlBreakRegex = regexp.MustCompile(`(?i)<\s*(br|/?p|/?div|/?li)\b[^>]*>`)
+var htmlTagRegex = regexp.MustCompile(`<[^>]*>`)
+var spaceRegex = regexp.MustCompile(`[ \t]+`)
+
+// stripHtml converts line breaking HTML tags to newlines, removes
+// all other tags and unescapes HTML entities
+func stripHtml(s string) string {
+	if !strings.ContainsAny(s, "<&") {
+		return s
+	}
+
+	s = htmlBreakRegex.ReplaceAllString(s, "\n")
+	s = htmlTagRegex.ReplaceAllString(s, "")
+	s = html.UnescapeString(s)
+
+	lines := strings.Split(s, "\n")
+	ret := make([]string, 0, len(lines))
+	for _, l := range lines {
+		l = strings.TrimSpace(spaceRegex.ReplaceAllString(l, " "))
+		if len(l) > 0 {
+			ret = append(ret, l)
+		}
+	}
+
+	return strings.Join(ret, "\n")
+}
+
+// desc returns the value written for a free-text description field
+func (o RowOptions) desc(s string) string {
+	if o.StripHtml {
+		return stripHtml(s)
+	}
+	return s
+}
+
+// desc returns the value written for a free-text description field
+// with the settings of the writer
+func (writer *Writer) desc(s string) string {
+	return writer.rowOptions().desc(s)
+}
@@ -0,0 +1,68 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"io"
+	"time"
+)
+
+// throttledWriter limits the throughput of all writes to the output of
+// a single write to MaxBytesPerSec
+type throttledWriter struct {
+	w      io.Writer
+	writer *Writer
+}
+
+// withThrottle wraps w into a throttledWriter, if MaxBytesPerSec is set
+func (writer *Writer) withThrottle(w io.Writer) io.Writer {
+	if writer.MaxBytesPerSec <= 0 {
+		return w
+	}
+	return &throttledWriter{w, writer}
+}
+
+func (tw *throttledWriter) Write(p []byte) (int, error) {
+	// write in chunks of a tenth of a second, for an even rate
+	chunk := int(tw.writer.MaxBytesPerSec / 10)
+	if chunk < 1 {
+		chunk = 1
+	}
+
+	written := 0
+	for written < len(p) {
+		end := written + chunk
+		if end > len(p) {
+			end = len(p)
+		}
+
+		n, e := tw.w.Write(p[written:end])
+		written += n
+		tw.writer.throttle(n)
+
+		if e != nil {
+			return written, e
+		}
+	}
+
+	return written, nil
+}
+
+// throttle accounts for n bytes written and sleeps until the average
+// throughput since the first write is back at MaxBytesPerSec
+func (writer *Writer) throttle(n int) {
+	if writer.throttleStart.IsZero() {
+		writer.throttleStart = time.Now()
+	}
+
+	writer.throttled += int64(n)
+
+	due := time.Duration(float64(writer.throttled) / float64(writer.MaxBytesPerSec) * float64(time.Second))
+	if wait := due - time.Since(writer.throttleStart); wait > 0 {
+		time.Sleep(wait)
+	}
+}
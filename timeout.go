@@ -0,0 +1,95 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// startDeadline starts the FileTimeout of the next file
+func (writer *Writer) startDeadline() {
+	if writer.FileTimeout > 0 {
+		writer.fileDeadline = time.Now().Add(writer.FileTimeout)
+	} else {
+		writer.fileDeadline = time.Time{}
+	}
+}
+
+// withDeadline runs fn, but gives up waiting for it once the deadline
+// of the current file has passed. A blocked operation on the output
+// cannot be interrupted, it is abandoned and left running.
+func (writer *Writer) withDeadline(fn func() error) error {
+	if writer.fileDeadline.IsZero() {
+		return fn()
+	}
+
+	wait := time.Until(writer.fileDeadline)
+	if wait <= 0 {
+		return writer.timeoutError()
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- fn() }()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case e := <-done:
+		return e
+	case <-timer.C:
+		return writer.timeoutError()
+	}
+}
+
+// timeoutError returns the error for a file which exceeded FileTimeout,
+// it is a timeout and thus retryable
+func (writer *Writer) timeoutError() error {
+	return fmt.Errorf("output did not finish within %v: %w", writer.FileTimeout, os.ErrDeadlineExceeded)
+}
+
+// deadlineWriter aborts writes which do not finish before the deadline
+// of the current file
+type deadlineWriter struct {
+	w      io.Writer
+	writer *Writer
+}
+
+// withFileTimeout wraps w into a deadlineWriter, if FileTimeout is set
+func (writer *Writer) withFileTimeout(w io.Writer) io.Writer {
+	if writer.FileTimeout <= 0 {
+		return w
+	}
+	return &deadlineWriter{w, writer}
+}
+
+func (dw *deadlineWriter) Write(p []byte) (int, error) {
+	if dw.writer.fileDeadline.IsZero() {
+		return dw.w.Write(p)
+	}
+
+	// the caller may reuse p once an abandoned write returned
+	buf := append([]byte(nil), p...)
+
+	written := make(chan int, 1)
+	e := dw.writer.withDeadline(func() error {
+		n, err := dw.w.Write(buf)
+		written <- n
+		return err
+	})
+
+	select {
+	case n := <-written:
+		return n, e
+	default:
+		// the write was abandoned
+		return 0, e
+	}
+}
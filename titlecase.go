@@ -0,0 +1,190 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// words written in lower case if they are not the first word of a
+// name, by language
+var titleCaseLower = map[string]map[string]bool{
+	"en": wordSet("an", "and", "at", "by", "for", "in", "of", "on", "or", "the", "to", "via"),
+	"de": wordSet("am", "an", "auf", "bei", "d.", "der", "die", "das", "dem", "den", "des", "im", "in", "ob", "u.", "und", "unter", "vor", "zum", "zur", "über"),
+	"fr": wordSet("à", "au", "aux", "d", "de", "des", "du", "en", "et", "l", "la", "le", "les", "sous", "sur"),
+	"nl": wordSet("aan", "bij", "de", "den", "der", "en", "het", "in", "op", "van"),
+	"es": wordSet("de", "del", "el", "en", "la", "las", "los", "y"),
+	"it": wordSet("al", "alla", "d", "da", "dei", "del", "della", "delle", "di", "e", "il", "in", "l", "la"),
+}
+
+// words always kept in upper case
+var titleCaseUpper = wordSet("ii", "iii", "iv", "vi", "vii", "viii", "ix", "xi", "xii", "xiii", "xiv", "xv", "zob", "cfp", "rer", "sncf", "tgv", "usa", "uk")
+
+func wordSet(words ...string) map[string]bool {
+	ret := make(map[string]bool, len(words))
+	for _, w := range words {
+		ret[w] = true
+	}
+	return ret
+}
+
+// prepareStopNames computes the names and platform codes written for
+// stops, according to DerivePlatformCodes and TitleCaseStopNames
+func (writer *Writer) prepareStopNames(feed *gtfsparser.Feed) {
+	writer.stopNames = make(map[*gtfs.Stop]string)
+	writer.platformCodes = make(map[*gtfs.Stop]string)
+
+	if !writer.TitleCaseStopNames && !writer.DerivePlatformCodes {
+		return
+	}
+
+	lang := writer.TitleCaseLang
+	if len(lang) == 0 {
+		lang = feedLang(feed)
+	}
+	lang = primaryLang(lang)
+
+	stops := make([]*gtfs.Stop, 0, len(feed.Stops)+len(writer.genStops))
+	for _, s := range feed.Stops {
+		if !writer.excl.stops[s] {
+			stops = append(stops, s)
+		}
+	}
+	stops = append(stops, writer.genStops...)
+
+	for _, s := range stops {
+		name := s.Name
+
+		if writer.DerivePlatformCodes {
+			if code, trimmed, ok := platformSuffix(s); ok {
+				writer.platformCodes[s] = code
+				writer.Report.PlatformCodes[s.Id] = code
+				writer.change("stops.txt", s.Id, "platform_code", ChangeFilled, "", code)
+				if writer.TrimPlatformNames {
+					name = trimmed
+				}
+			}
+		}
+
+		if writer.TitleCaseStopNames && isAllCaps(name) {
+			name = titleCase(name, lang)
+		}
+
+		if name != s.Name {
+			writer.stopNames[s] = name
+			writer.change("stops.txt", s.Id, "stop_name", ChangeModified, s.Name, name)
+		}
+	}
+}
+
+// stopName returns the name written for a stop
+func (writer *Writer) stopName(s *gtfs.Stop) string {
+	if name, ok := writer.stopNames[s]; ok {
+		return name
+	}
+	return s.Name
+}
+
+// feedLang returns the language of the agency with the smallest ID
+func feedLang(feed *gtfsparser.Feed) string {
+	ids := make([]string, 0, len(feed.Agencies))
+	for id := range feed.Agencies {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		if l := feed.Agencies[id].Lang.GetLangString(); len(l) > 0 {
+			return strings.ToLower(l)
+		}
+	}
+
+	return "en"
+}
+
+// primaryLang returns the lower cased primary subtag of a language
+// tag, e.g. "de" for "de-DE"
+func primaryLang(tag string) string {
+	if i := strings.IndexAny(tag, "-_"); i >= 0 {
+		tag = tag[:i]
+	}
+	return strings.ToLower(tag)
+}
+
+// isAllCaps checks whether a string contains letters, but no lower
+// case letters
+func isAllCaps(s string) bool {
+	hasLetter := false
+	for _, r := range s {
+		if unicode.IsLower(r) {
+			return false
+		}
+		if unicode.IsLetter(r) {
+			hasLetter = true
+		}
+	}
+	return hasLetter
+}
+
+// titleCase converts an upper case name to title case, using the
+// exceptions for the given language
+func titleCase(s string, lang string) string {
+	lower := titleCaseLower[lang]
+	runes := []rune(s)
+	ret := make([]rune, 0, len(runes))
+	first := true
+
+	for i := 0; i < len(runes); {
+		if !unicode.IsLetter(runes[i]) && !unicode.IsDigit(runes[i]) {
+			ret = append(ret, runes[i])
+			i++
+			continue
+		}
+
+		// collect a single word, including a trailing period
+		j := i
+		for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j])) {
+			j++
+		}
+		if j < len(runes) && runes[j] == '.' {
+			j++
+		}
+
+		word := string(runes[i:j])
+		lw := strings.ToLower(word)
+		bare := strings.TrimSuffix(lw, ".")
+
+		// elisions like "L'" or "D'" are always followed by a word
+		elision := j < len(runes) && runes[j] == '\'' && len([]rune(bare)) == 1
+
+		switch {
+		case titleCaseUpper[bare] || strings.IndexFunc(word, unicode.IsDigit) != -1:
+			ret = append(ret, []rune(word)...)
+		case i > 0 && runes[i-1] == '\'' && !elision && len([]rune(bare)) == 1:
+			// possessive "'s"
+			ret = append(ret, []rune(lw)...)
+		case !first && (lower[lw] || lower[bare]):
+			ret = append(ret, []rune(lw)...)
+		case len([]rune(bare)) == 1 && !elision:
+			// single letters are mostly line or platform designators
+			ret = append(ret, []rune(word)...)
+		default:
+			w := []rune(lw)
+			w[0] = unicode.ToTitle(w[0])
+			ret = append(ret, w...)
+		}
+
+		first = false
+		i = j
+	}
+
+	return string(ret)
+}
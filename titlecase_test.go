@@ -0,0 +1,37 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPrimaryLang(t *testing.T) {
+	for tag, want := range map[string]string{"de": "de", "de-DE": "de", "DE_at": "de", "": ""} {
+		if got := primaryLang(tag); got != want {
+			t.Errorf("primaryLang(%q) = %q, want %q", tag, got, want)
+		}
+	}
+}
+
+func TestTitleCaseRegionTag(t *testing.T) {
+	feed := stationFeed()
+	feed.Stops["ST1"].Name = "HAUPTBAHNHOF AM MARKT"
+
+	w := Writer{TitleCaseStopNames: true, TitleCaseLang: "de-DE"}
+
+	files, e := w.WriteToMemory(feed)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	stops := string(files["stops.txt"])
+	if !strings.Contains(stops, "Hauptbahnhof am Markt") {
+		t.Errorf("German exceptions not applied for de-DE:\n%s", stops)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type tripsByDeparture []*gtfs.Trip
+
+func (t tripsByDeparture) Len() int      { return len(t) }
+func (t tripsByDeparture) Swap(i, j int) { t[i], t[j] = t[j], t[i] }
+func (t tripsByDeparture) Less(i, j int) bool {
+	a := firstDeparture(t[i])
+	b := firstDeparture(t[j])
+	if a != b {
+		return a < b
+	}
+	return t[i].Id < t[j].Id
+}
+
+// firstDeparture returns the first departure of a trip in seconds
+// since midnight, or -1 if the trip has no stop times
+func firstDeparture(t *gtfs.Trip) int {
+	if len(t.StopTimes) == 0 {
+		return -1
+	}
+	return timeToSeconds(t.StopTimes[0].Departure_time())
+}
+
+// prepareTripIds renders new trip IDs from TripIdTemplate. Supported
+// placeholders are {route}, {service}, {direction}, {trip} (the original
+// trip ID) and {seq}, a running number of trips with otherwise identical
+// IDs, ordered by their first departure.
+func (writer *Writer) prepareTripIds(feed *gtfsparser.Feed) {
+	writer.tripIds = make(map[*gtfs.Trip]string)
+
+	if len(writer.TripIdTemplate) == 0 {
+		return
+	}
+
+	groups := make(map[string]tripsByDeparture)
+
+	for _, t := range feed.Trips {
+		routeId := ""
+		if t.Route != nil {
+			routeId = writer.route(t.Route).Id
+		}
+		serviceId := ""
+		if t.Service != nil {
+			serviceId = t.Service.Id()
+		}
+
+		id := strings.NewReplacer(
+			"{route}", routeId,
+			"{service}", serviceId,
+			"{direction}", strconv.Itoa(int(t.Direction_id)),
+			"{trip}", t.Id,
+		).Replace(writer.TripIdTemplate)
+
+		groups[id] = append(groups[id], t)
+	}
+
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	used := make(map[string]bool, len(feed.Trips))
+
+	for _, key := range keys {
+		trips := groups[key]
+		sort.Sort(trips)
+
+		for i, t := range trips {
+			id := strings.Replace(key, "{seq}", strconv.Itoa(i+1), -1)
+
+			// make sure the rendered IDs are unique
+			base := id
+			for j := 2; used[id]; j++ {
+				id = base + "_" + strconv.Itoa(j)
+			}
+			used[id] = true
+
+			writer.tripIds[t] = id
+			writer.Report.TripIds[t.Id] = id
+			if id != t.Id {
+				writer.change("trips.txt", t.Id, "trip_id", ChangeModified, t.Id, id)
+			}
+		}
+	}
+}
+
+// tripId returns the ID written for a trip
+func (writer *Writer) tripId(t *gtfs.Trip) string {
+	if id, ok := writer.tripIds[t]; ok {
+		return id
+	}
+	return t.Id
+}
@@ -0,0 +1,89 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+)
+
+// An openBackend can read the files it holds
+type openBackend interface {
+	Open(name string) (io.ReadCloser, error)
+}
+
+// skipUnchanged checks whether files written to a directory are only
+// written if their content changed
+func (writer *Writer) skipUnchanged() bool {
+	_, ok := writer.backend().(openBackend)
+	return writer.SkipUnchanged && ok
+}
+
+// unchangedFile holds the content of a file written to a directory,
+// which is only written to the backend on Close if it differs from
+// the existing file
+type unchangedFile struct {
+	writer *Writer
+	name   string
+	stats  *FileStats
+	buf    bytes.Buffer
+}
+
+func (u *unchangedFile) Write(p []byte) (int, error) {
+	return u.buf.Write(p)
+}
+
+func (u *unchangedFile) Close() error {
+	if u.unchanged() {
+		u.stats.Unchanged = true
+		return nil
+	}
+
+	f, e := u.writer.backend().Create(u.name)
+	if e != nil {
+		return e
+	}
+	u.writer.created = append(u.writer.created, u.name)
+
+	if _, e := f.Write(u.buf.Bytes()); e != nil {
+		f.Close()
+		return e
+	}
+
+	if u.writer.Durable {
+		if e := syncFile(f); e != nil {
+			f.Close()
+			return e
+		}
+	}
+
+	return f.Close()
+}
+
+// unchanged checks whether the existing file has the same content,
+// files of equal size are compared by their SHA-256
+func (u *unchangedFile) unchanged() bool {
+	fi, e := u.writer.backend().Stat(u.name)
+	if e != nil || fi.IsDir() || fi.Size() != int64(u.buf.Len()) {
+		return false
+	}
+
+	f, e := u.writer.backend().(openBackend).Open(u.name)
+	if e != nil {
+		return false
+	}
+	defer f.Close()
+
+	old := sha256.New()
+	if _, e := io.Copy(old, f); e != nil {
+		return false
+	}
+
+	sum := sha256.Sum256(u.buf.Bytes())
+	return bytes.Equal(old.Sum(nil), sum[:])
+}
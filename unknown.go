@@ -0,0 +1,144 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// writtenFiles returns the names of all files the writer itself may
+// write to the output
+func (writer *Writer) writtenFiles() map[string]bool {
+	names := make(map[string]bool)
+	for _, t := range writer.tables(nil) {
+		names[t.name] = true
+	}
+	for name := range writer.AuxFiles {
+		names[name] = true
+	}
+	for _, f := range writer.extraFiles {
+		names[f.name] = true
+	}
+	if writer.WriteWarnings {
+		names["warnings.csv"] = true
+	}
+	return names
+}
+
+// copyUnknownFiles copies all files of the source feed in CopyUnknownFrom
+// which the writer does not write itself verbatim into the output
+func (writer *Writer) copyUnknownFiles(path string) error {
+	src := writer.CopyUnknownFrom
+
+	srcInfo, e := os.Stat(src)
+	if e != nil {
+		return writeError{src, e.Error()}
+	}
+
+	// files are copied into the ZIP file of WriteToZip one by one
+	isDir := false
+
+	if writer.extZip == nil {
+		dstInfo, e := writer.backend().Stat(path)
+		if e != nil {
+			return writeError{path, e.Error()}
+		}
+
+		if writer.local() && os.SameFile(srcInfo, dstInfo) {
+			if srcInfo.IsDir() {
+				// unknown files were never removed
+				return nil
+			}
+			return writeError{src, "cannot copy unknown files from the output ZIP file"}
+		}
+
+		isDir = dstInfo.IsDir()
+	}
+
+	known := writer.writtenFiles()
+
+	if srcInfo.IsDir() {
+		return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			rel, err := filepath.Rel(src, p)
+			if err != nil {
+				return err
+			}
+			name := filepath.ToSlash(rel)
+			if known[name] {
+				return nil
+			}
+			f, err := os.Open(p)
+			if err != nil {
+				return writeError{name, err.Error()}
+			}
+			defer f.Close()
+			return writer.copyUnknownFile(path, isDir, name, f)
+		})
+	}
+
+	r, e := openZip(src)
+	if e != nil {
+		return writeError{src, e.Error()}
+	}
+	defer r.Close()
+
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() || known[f.Name] {
+			continue
+		}
+
+		if writer.zipFile != nil {
+			// copy the compressed member as is
+			if e := writer.zipFile.Copy(f); e != nil {
+				return writeError{f.Name, e.Error()}
+			}
+			continue
+		}
+
+		rc, e := f.Open()
+		if e != nil {
+			return writeError{f.Name, e.Error()}
+		}
+		e = writer.copyUnknownFile(path, isDir, f.Name, rc)
+		rc.Close()
+		if e != nil {
+			return e
+		}
+	}
+
+	return nil
+}
+
+// copyUnknownFile copies the content of a single unknown file into the
+// output
+func (writer *Writer) copyUnknownFile(path string, isDir bool, name string, r io.Reader) error {
+	if isDir {
+		if e := writer.mkdirs(path, name); e != nil {
+			return writeError{name, e.Error()}
+		}
+	}
+
+	file, e := writer.getFileForWriting(path, name)
+	if e != nil {
+		return writeError{name, e.Error()}
+	}
+
+	if _, e := io.Copy(file, r); e != nil {
+		return writeError{name, e.Error()}
+	}
+
+	return writer.closeFile()
+}
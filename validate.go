@@ -0,0 +1,101 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"fmt"
+	"github.com/patrickbr/gtfsparser"
+	"sort"
+	"strings"
+)
+
+// A ValidationError is a reference of an entity to another entity
+// which is not part of the feed
+type ValidationError struct {
+	// file and ID of the referencing entity
+	File     string
+	EntityId string
+
+	// column of the reference and the referenced ID
+	Field string
+	Ref   string
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("%s (%s, %s) - reference to missing entity '%s'", e.File, e.EntityId, e.Field, e.Ref)
+}
+
+// ValidationErrors is the error returned by StrictWrite for a feed
+// with dangling references
+type ValidationErrors []ValidationError
+
+func (errs ValidationErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Error()
+	}
+	return "feed has dangling references: " + strings.Join(msgs, "; ")
+}
+
+type validationErrorList []ValidationError
+
+func (vl validationErrorList) Len() int      { return len(vl) }
+func (vl validationErrorList) Swap(i, j int) { vl[i], vl[j] = vl[j], vl[i] }
+func (vl validationErrorList) Less(i, j int) bool {
+	if vl[i].File != vl[j].File {
+		return vl[i].File < vl[j].File
+	}
+	if vl[i].EntityId != vl[j].EntityId {
+		return vl[i].EntityId < vl[j].EntityId
+	}
+	return vl[i].Field < vl[j].Field
+}
+
+// Validate checks the references between the entities of a feed:
+// parent stations and levels of stops, agencies of routes, and routes,
+// services and shapes of trips must be part of the feed. It returns
+// all dangling references, ordered by file and entity ID. Levels are
+// not checked if MissingLevels is MissingRefCreate, as the writer
+// creates placeholders for them.
+func (writer *Writer) Validate(feed *gtfsparser.Feed) []ValidationError {
+	ret := make(validationErrorList, 0)
+
+	for _, s := range feed.Stops {
+		if p := s.Parent_station; p != nil && feed.Stops[p.Id] != p {
+			ret = append(ret, ValidationError{"stops.txt", s.Id, "parent_station", p.Id})
+		}
+		if l := s.Level; l != nil && feed.Levels[l.Id] != l && writer.MissingLevels != MissingRefCreate {
+			ret = append(ret, ValidationError{"stops.txt", s.Id, "level_id", l.Id})
+		}
+	}
+
+	for _, r := range feed.Routes {
+		if a := r.Agency; a != nil && feed.Agencies[a.Id] != a {
+			ret = append(ret, ValidationError{"routes.txt", r.Id, "agency_id", a.Id})
+		}
+	}
+
+	for _, t := range feed.Trips {
+		if r := t.Route; r == nil {
+			ret = append(ret, ValidationError{"trips.txt", t.Id, "route_id", ""})
+		} else if feed.Routes[r.Id] != r {
+			ret = append(ret, ValidationError{"trips.txt", t.Id, "route_id", r.Id})
+		}
+		if s := t.Service; s == nil {
+			ret = append(ret, ValidationError{"trips.txt", t.Id, "service_id", ""})
+		} else if feed.Services[s.Id()] != s {
+			ret = append(ret, ValidationError{"trips.txt", t.Id, "service_id", s.Id()})
+		}
+		if s := t.Shape; s != nil && feed.Shapes[s.Id] != s {
+			ret = append(ret, ValidationError{"trips.txt", t.Id, "shape_id", s.Id})
+		}
+	}
+
+	sort.Sort(ret)
+
+	return ret
+}
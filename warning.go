@@ -0,0 +1,97 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// A CheckPolicy defines how the writer reacts to failed checks
+type CheckPolicy int
+
+const (
+	// CheckOff disables a check
+	CheckOff CheckPolicy = iota
+	// CheckWarn reports failed checks as warnings
+	CheckWarn
+	// CheckFail aborts writing on failed checks
+	CheckFail
+)
+
+// A Warning describes a non-fatal problem found during writing
+type Warning struct {
+	File     string
+	EntityId string
+	Field    string
+	Msg      string
+}
+
+func (w Warning) String() string {
+	if len(w.EntityId) > 0 {
+		return fmt.Sprintf("%s (%s, %s) - %s", w.File, w.EntityId, w.Field, w.Msg)
+	}
+	return fmt.Sprintf("%s - %s", w.File, w.Msg)
+}
+
+type warningList []Warning
+
+func (wl warningList) Len() int      { return len(wl) }
+func (wl warningList) Swap(i, j int) { wl[i], wl[j] = wl[j], wl[i] }
+func (wl warningList) Less(i, j int) bool {
+	if wl[i].File != wl[j].File {
+		return wl[i].File < wl[j].File
+	}
+	return wl[i].EntityId < wl[j].EntityId
+}
+
+// warn records a warning and passes it to the warning handler, if any
+func (writer *Writer) warn(file string, id string, field string, msg string) {
+	w := Warning{file, id, field, msg}
+	writer.warnings = append(writer.warnings, w)
+
+	if writer.WarningHandler != nil {
+		writer.WarningHandler(w)
+	}
+}
+
+// writeWarnings writes the collected warnings to warnings.csv
+func (writer *Writer) writeWarnings(path string) (err error) {
+	if len(writer.warnings) == 0 {
+		return writer.delExistingFile(path, "warnings.csv")
+	}
+	file, e := writer.getFileForWriting(path, "warnings.csv")
+
+	if e != nil {
+		return errors.New("Could not open file warnings.csv for writing")
+	}
+
+	csvwriter := writer.newCsvWriter(file, "warnings.csv")
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = writer.recovered("warnings.csv", r)
+		}
+	}()
+
+	csvwriter.SetHeader([]string{"file", "entity_id", "field", "message"}, []string{"file", "entity_id", "field", "message"})
+
+	// warnings from map iterations are not in a stable order
+	list := append(warningList(nil), writer.warnings...)
+	sort.Stable(list)
+
+	for _, w := range list {
+		csvwriter.WriteCsvLine([]string{w.File, w.EntityId, w.Field, w.Msg})
+	}
+
+	if fe := csvwriter.Flush(); fe != nil {
+		return writeError{"warnings.csv", fe.Error()}
+	}
+
+	return e
+}
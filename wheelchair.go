@@ -0,0 +1,98 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"fmt"
+	"github.com/patrickbr/gtfsparser"
+	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// checkWheelchair compares the wheelchair_accessible flag of all trips
+// with the wheelchair_boarding of the stops they serve. Trips marked
+// accessible which only serve inaccessible stops (and vice versa) are
+// reported according to the WheelchairCheck policy, and written with
+// an unknown accessibility if DowngradeWheelchair is set.
+func (writer *Writer) checkWheelchair(feed *gtfsparser.Feed) error {
+	writer.wheelchairConflicts = make(map[*gtfs.Trip]bool)
+
+	if writer.WheelchairCheck == CheckOff && !writer.DowngradeWheelchair {
+		return nil
+	}
+
+	invalid := make([]string, 0)
+
+	for _, t := range feed.Trips {
+		if t.Wheelchair_accessible == 0 || len(t.StopTimes) == 0 {
+			continue
+		}
+
+		conflict := true
+		for i := range t.StopTimes {
+			wb := writer.wheelchairBoarding(t.StopTimes[i].Stop())
+			if wb == 0 || wb == t.Wheelchair_accessible {
+				conflict = false
+				break
+			}
+		}
+
+		if !conflict {
+			continue
+		}
+
+		id := writer.tripId(t)
+		writer.wheelchairConflicts[t] = true
+		writer.Report.WheelchairConflicts = append(writer.Report.WheelchairConflicts, id)
+
+		if writer.DowngradeWheelchair {
+			writer.change("trips.txt", t.Id, "wheelchair_accessible", ChangeModified, strconv.Itoa(int(t.Wheelchair_accessible)), "0")
+		}
+
+		if writer.WheelchairCheck == CheckFail {
+			invalid = append(invalid, "'"+id+"'")
+		} else if writer.WheelchairCheck == CheckWarn {
+			if t.Wheelchair_accessible == 1 {
+				writer.warn("trips.txt", id, "wheelchair_accessible", "trip is marked accessible, but serves only inaccessible stops")
+			} else {
+				writer.warn("trips.txt", id, "wheelchair_accessible", "trip is marked inaccessible, but serves only accessible stops")
+			}
+		}
+	}
+
+	sort.Strings(writer.Report.WheelchairConflicts)
+
+	if len(invalid) > 0 {
+		sort.Strings(invalid)
+		return writeError{"trips.txt", fmt.Sprintf("wheelchair accessibility of trips conflicts with their stops: %s", strings.Join(invalid, ", "))}
+	}
+
+	return nil
+}
+
+// wheelchairBoarding returns the effective wheelchair_boarding of a
+// stop, which is inherited from the parent station if unset
+func (writer *Writer) wheelchairBoarding(s *gtfs.Stop) int8 {
+	s = writer.stop(s)
+	if s.Wheelchair_boarding == 0 {
+		if parent := writer.parentStation(s); parent != nil {
+			return parent.Wheelchair_boarding
+		}
+	}
+	return s.Wheelchair_boarding
+}
+
+// wheelchairAccessible returns the wheelchair_accessible value written
+// for a trip
+func (writer *Writer) wheelchairAccessible(t *gtfs.Trip) int8 {
+	if writer.DowngradeWheelchair && writer.wheelchairConflicts[t] {
+		return 0
+	}
+	return t.Wheelchair_accessible
+}
@@ -8,6 +8,7 @@ package gtfswriter
 
 import (
 	"fmt"
+	"strings"
 )
 
 type writeError struct {
@@ -18,3 +19,45 @@ type writeError struct {
 func (e writeError) Error() string {
 	return fmt.Sprintf("%s - %s", e.filename, e.msg)
 }
+
+// recovered converts a panic recovered while writing file into an
+// error. In Debug mode, the panic is propagated instead, so that it
+// crashes with the stack trace of its origin.
+func (writer *Writer) recovered(file string, r interface{}) error {
+	if writer.Debug {
+		panic(r)
+	}
+	if e, ok := r.(error); ok {
+		return writeError{file, e.Error()}
+	}
+	return writeError{file, fmt.Sprint(r)}
+}
+
+// WriteErrors holds the errors of all files which could not be
+// written if ContinueOnError is set
+type WriteErrors []error
+
+func (errs WriteErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (errs WriteErrors) Unwrap() []error {
+	return errs
+}
+
+// tableFailed records the error of a file which could not be written
+// if ContinueOnError is set, and removes the incomplete file from an
+// output directory. Otherwise, the error is returned.
+func (writer *Writer) tableFailed(path string, name string, e error) error {
+	if !writer.ContinueOnError {
+		return e
+	}
+
+	writer.failed = append(writer.failed, e)
+
+	return writer.delExistingFile(path, name)
+}
@@ -9,11 +9,14 @@ package gtfswriter
 import (
 	// "archive/zip"
 	"compress/flate"
+	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"github.com/klauspost/compress/zip"
 	"github.com/patrickbr/gtfsparser"
 	gtfs "github.com/patrickbr/gtfsparser/gtfs"
+	"hash"
 	"io"
 	"math"
 	"os"
@@ -22,6 +25,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type EntAttr struct {
@@ -31,145 +35,520 @@ type EntAttr struct {
 	trip   *gtfs.Trip
 }
 
+// ids returns the agency, route and trip ID the attribution is bound to
+func (ea EntAttr) ids() (string, string, string) {
+	agencyid := ""
+	routeid := ""
+	tripid := ""
+
+	if ea.agency != nil {
+		agencyid = ea.agency.Id
+	}
+	if ea.route != nil {
+		routeid = ea.route.Id
+	}
+	if ea.trip != nil {
+		tripid = ea.trip.Id
+	}
+
+	return agencyid, routeid, tripid
+}
+
+type entAttrs []EntAttr
+
+func (ea entAttrs) Len() int      { return len(ea) }
+func (ea entAttrs) Swap(i, j int) { ea[i], ea[j] = ea[j], ea[i] }
+func (ea entAttrs) Less(i, j int) bool {
+	if ea[i].attr.Organization_name != ea[j].attr.Organization_name {
+		return ea[i].attr.Organization_name < ea[j].attr.Organization_name
+	}
+
+	aI, rI, tI := ea[i].ids()
+	aJ, rJ, tJ := ea[j].ids()
+
+	if aI != aJ {
+		return aI < aJ
+	}
+	if rI != rJ {
+		return rI < rJ
+	}
+	if tI != tJ {
+		return tI < tJ
+	}
+	return ea[i].attr.Id < ea[j].attr.Id
+}
+
 // A Writer for GTFS files
 type Writer struct {
 	//case write in Dir
-	curFileHandle *os.File
+	curFileHandle io.WriteCloser
+	curFileName   string
 	//case write in File
-	zipFile             *zip.Writer
-	ZipCompressionLevel int
-	Sorted              bool
-	ExplicitCalendar    bool
-	KeepColOrder        bool
-	DontGarbageCollect  bool
-	buff                []byte
+	zipFile                *zip.Writer
+	zipHandle              io.WriteCloser
+	ZipCompressionLevel    int
+	Sorted                 bool
+	SortKeys               map[string][]SortKey
+	NaturalSort            bool
+	ExplicitCalendar       bool
+	KeepColOrder           bool
+	DontGarbageCollect     bool
+	GenerateShapes         bool
+	CurrencyCheck          CheckPolicy
+	SymmetricTransfers     bool
+	ReversePathways        bool
+	MissingLevels          MissingRefPolicy
+	FrequencyOverlaps      OverlapPolicy
+	CompactBlockIds        bool
+	DropSingleTripBlocks   bool
+	CompactZoneIds         bool
+	ZoneIdMappingFile      string
+	GenerateParentStations bool
+	ParentStationDist      float64
+	MergeStopsDist         float64
+	MergeRoutes            bool
+	FillHeadsigns          bool
+	TripIdTemplate         string
+	StripHtml              bool
+	TitleCaseStopNames     bool
+	TitleCaseLang          string
+	WheelchairCheck        CheckPolicy
+	DowngradeWheelchair    bool
+	BikesAllowedDefaults   map[int16]int8
+	DerivePlatformCodes    bool
+	TrimPlatformNames      bool
+	RecordChanges          bool
+	ChangeReportFile       string
+	WriteWarnings          bool
+	ComputeQuality         bool
+	DuplicateCheck         CheckPolicy
+	DropDuplicates         bool
+	StopTimesCheck         CheckPolicy
+	ConflictCheck          CheckPolicy
+	PartialFeed            PartialFeedPolicy
+	ShapeDistCheck         CheckPolicy
+	MaxShapeDist           float64
+	SchemaFile             string
+	BatchWorkers           int
+	AuxFiles               map[string][]byte
+	CopyUnknownFrom        string
+	Debug                  bool
+	Retries                int
+	RetryBackoff           time.Duration
+	RetryIf                func(error) bool
+	Preflight              bool
+	Durable                bool
+	CleanupOnError         bool
+	SecondsColumns         bool
+	FileTimeout            time.Duration
+	MaxBytesPerSec         int64
+	MetadataFile           string
+	MetadataSource         string
+	SourceDistUnit         DistUnit
+	TargetDistUnit         DistUnit
+	DistDecimals           int
+	FalseValues            map[string]FalseValue
+	CalendarMinDays        int
+	Backend                OutputBackend
+	StreamStopTimes        bool
+	Parallelism            int
+	OnlyFiles              []string
+	SkipFiles              []string
+	Filter                 Filter
+	DryRun                 bool
+	StrictWrite            bool
+	ContinueOnError        bool
+	Compression            CompressionMethod
+	GzipFiles              bool
+	SkipUnchanged          bool
+	Atomic                 bool
+	Quoting                QuotePolicy
+	KeepAllColumns         bool
+	ExplicitDefaults       bool
+	KeepNewlines           bool
+	Checksums              bool
+	ChecksumFile           string
+	RowHook                func(table string, header []string, row []string) []string
+	ZipComment             string
+	ZipModified            time.Time
+	QualityHorizonStart    time.Time
+	QualityHorizonDays     int
+	WarningHandler         func(Warning)
+	Report                 Report
+	buff                   []byte
+
+	// warnings collected during the current write
+	warnings []Warning
+
+	// shapes synthesized for trips without a shape
+	tripShapes map[*gtfs.Trip]*gtfs.Shape
+	genShapes  []*gtfs.Shape
+
+	// placeholders for missing levels
+	genLevels []*gtfs.Level
+
+	// entities dropped from the output
+	excl exclusions
+
+	// mapping of original to written block IDs
+	blockIds map[string]string
+
+	// merged stops, mapped to the stop they were merged into
+	stopMap map[*gtfs.Stop]*gtfs.Stop
+
+	// merged routes, mapped to the route they were merged into
+	routeMap map[*gtfs.Route]*gtfs.Route
+
+	// headsigns derived for trips without one
+	headsigns map[*gtfs.Trip]string
+
+	// trip IDs rendered from the trip ID template
+	tripIds map[*gtfs.Trip]string
+
+	// output of WriteFile
+	single io.Writer
+
+	// ZIP file of WriteToZip, and the prefix of the written files
+	extZip    *zip.Writer
+	zipPrefix string
+
+	// files written by WriteToMemory
+	mem *memBackend
+
+	// additional files registered with RegisterExtraTable
+	extraTables []extraTable
+
+	// files added with AddExtraFile for the next write
+	extraFiles []extraFile
+
+	// checksum of the ZIP file written, and whether it is written by
+	// WriteToStream
+	archiveHash hash.Hash
+	stream      bool
+
+	// last error returned by the output, for retries
+	sinkErr error
+
+	// services written to calendar_dates.txt only
+	datesOnly map[*gtfs.Service]bool
+
+	// files and directories created by the current write
+	created []string
+
+	// errors of the files which could not be written, for ContinueOnError
+	failed []error
+
+	// deadline of the file currently written, zero if none
+	fileDeadline time.Time
+
+	// start of the first write and bytes written, for MaxBytesPerSec
+	throttleStart time.Time
+	throttled     int64
+
+	// context of WriteContext, nil if none
+	ctx context.Context
+
+	// builder whose streamed stop times are written
+	streamed *Builder
+
+	// names written for stops, if different from the original
+	stopNames map[*gtfs.Stop]string
+
+	// platform codes derived from stop names
+	platformCodes map[*gtfs.Stop]string
+
+	// attributions not written because they are duplicates
+	dupAttrs map[EntAttr]bool
+
+	// attribution IDs changed to resolve duplicates
+	attrIds map[EntAttr]string
+
+	// trips whose accessibility conflicts with their stops
+	wheelchairConflicts map[*gtfs.Trip]bool
+
+	// stations generated for platforms without a parent
+	parents  map[*gtfs.Stop]*gtfs.Stop
+	genStops []*gtfs.Stop
 }
 
 // Write a single GTFS feed to a system path, either a folder or a ZIP file
 func (writer *Writer) Write(feed *gtfsparser.Feed, path string) error {
-	writer.buff = make([]byte, 0, 64)
-	var e error
+	start := time.Now()
+	defer func() { writer.Report.Duration = time.Since(start) }()
 
-	// collected route, trip and agency attributions
-	attributions := make([]EntAttr, 0)
+	// extra files are consumed by a single write
+	defer func() { writer.extraFiles = nil }()
 
-	e = writer.writeAgencies(path, feed, &attributions)
+	writer.curFileHandle = nil
+	writer.zipFile = nil
+	writer.zipHandle = nil
+	writer.archiveHash = nil
+	writer.created = nil
+	writer.failed = nil
 
-	if e == nil {
-		e = writer.writeFeedInfos(path, feed)
+	if writer.StrictWrite {
+		if errs := writer.Validate(feed); len(errs) > 0 {
+			return ValidationErrors(errs)
+		}
 	}
-	if !writer.DontGarbageCollect {
-		runtime.GC()
-    }
-	if e == nil {
-		e = writer.writeStops(path, feed)
+
+	if writer.Preflight && writer.extZip == nil {
+		if e := writer.preflight(feed, path); e != nil {
+			return e
+		}
 	}
-	if !writer.DontGarbageCollect {
-		runtime.GC()
-    }
-	if e == nil {
-		e = writer.writeShapes(path, feed)
+
+	// the output is written to a temporary path and moved to target
+	target := path
+	if writer.atomic() {
+		tmp, e := writer.atomicTemp(path)
+		if e != nil {
+			return e
+		}
+		path = tmp
+		defer os.RemoveAll(tmp)
 	}
-	if !writer.DontGarbageCollect {
-		runtime.GC()
-    }
+
+	feed, attributions, e := writer.prepare(feed)
+
+	var tables []table
 	if e == nil {
-		e = writer.writeRoutes(path, feed, &attributions)
+		tables, e = writer.selectedTables(attributions)
 	}
-	if !writer.DontGarbageCollect {
-		runtime.GC()
-    }
-	if e == nil {
-		e = writer.writeCalendar(path, feed)
+
+	if e == nil && writer.parallel(path) {
+		e = writer.writeParallel(attributions, path, feed)
+	} else {
+		for _, t := range tables {
+			if e == nil {
+				e = writer.canceled(t.name)
+			}
+			if e != nil {
+				break
+			}
+			e = writer.writeRetrying(t, path, feed)
+			if e != nil {
+				e = writer.tableFailed(path, t.name, e)
+			}
+			if !writer.DontGarbageCollect {
+				runtime.GC()
+			}
+		}
 	}
-	if !writer.DontGarbageCollect {
-		runtime.GC()
-    }
-	if e == nil {
-		e = writer.writeCalendarDates(path, feed)
+
+	if e == nil && writer.WriteWarnings {
+		e = writer.writeWarnings(path)
 	}
-	if !writer.DontGarbageCollect {
-		runtime.GC()
-    }
+
 	if e == nil {
-		e = writer.writeTrips(path, feed, &attributions)
+		e = writer.closeFile()
+	}
+
+	if e == nil && (len(writer.AuxFiles) > 0 || len(writer.extraFiles) > 0) {
+		e = writer.writeAuxFiles(path)
+	}
+
+	if e == nil && len(writer.CopyUnknownFrom) > 0 {
+		e = writer.copyUnknownFiles(path)
+	}
+
+	if e != nil {
+		writer.closeFile()
+		writer.closeZip(path)
+		writer.cleanup()
+		return e
+	}
+
+	e = writer.closeZip(path)
+
+	if e == nil && writer.Durable && writer.local() {
+		e = writer.syncDir(path)
+	}
+
+	if e != nil {
+		writer.cleanup()
+		return e
+	}
+
+	// the ZIP file can only be read back from the local file system
+	if e == nil && writer.zipFile != nil && writer.local() {
+		e = writer.compressionStats(path)
 	}
-	if !writer.DontGarbageCollect {
-		runtime.GC()
-    }
+
+	if e == nil && target != path {
+		e = writer.commitAtomic(path, target)
+	}
+
+	writer.finishChecksums()
+	writer.sortChanges()
+
 	if e == nil {
-		e = writer.writeStopTimes(path, feed)
+		e = writer.writeChangeReport()
 	}
-	if !writer.DontGarbageCollect {
-		runtime.GC()
-    }
+
 	if e == nil {
-		e = writer.writeFareAttributes(path, feed)
+		e = writer.writeZoneIdMapping()
 	}
-	if !writer.DontGarbageCollect {
-		runtime.GC()
-    }
+
 	if e == nil {
-		e = writer.writeFareAttributeRules(path, feed)
+		e = writer.writeSchema()
 	}
-	if !writer.DontGarbageCollect {
-		runtime.GC()
-    }
+
 	if e == nil {
-		e = writer.writeFrequencies(path, feed)
+		e = writer.writeMetadata()
 	}
-	if !writer.DontGarbageCollect {
-		runtime.GC()
-    }
+
+	// the checksum of a streamed archive is only known once it is closed
+	if e == nil && !writer.stream {
+		e = writer.writeChecksumFile(opath.Base(target))
+	}
+
+	if e == nil && len(writer.failed) > 0 {
+		return WriteErrors(writer.failed)
+	}
+
+	return e
+}
+
+// prepare resets the per-write state, applies the write-time
+// transformations and runs the checks. It returns the feed to be
+// written, which is reduced to the part selected by Filter, and the
+// route, trip and agency attributions to be written.
+func (writer *Writer) prepare(feed *gtfsparser.Feed) (*gtfsparser.Feed, entAttrs, error) {
+	writer.buff = make([]byte, 0, 64)
+	writer.warnings = nil
+	writer.Report = newReport()
+	writer.excl = newExclusions()
+	writer.throttleStart = time.Time{}
+	writer.throttled = 0
+	feed = writer.filterFeed(feed)
+	writer.excl.cascade(feed)
+	writer.recordExclusions()
+	writer.mergeStops(feed)
+	writer.mergeRoutes(feed)
+	writer.generateShapes(feed)
+	writer.prepareBlockIds(feed)
+	writer.prepareZoneIds(feed)
+	writer.generateParentStations(feed)
+	writer.prepareStopNames(feed)
+	writer.fillHeadsigns(feed)
+	writer.prepareTripIds(feed)
+	writer.prepareCalendars(feed)
+
+	writer.computeQuality(feed)
+
+	e := writer.prepareLevels(feed)
+
 	if e == nil {
-		e = writer.writeTransfers(path, feed)
+		e = writer.checkWheelchair(feed)
 	}
-	if !writer.DontGarbageCollect {
-		runtime.GC()
-    }
+
+	// route, trip and agency attributions
+	attributions := writer.collectAttributions(feed)
+
 	if e == nil {
-		e = writer.writeLevels(path, feed)
+		e = writer.checkDuplicates(feed, attributions)
 	}
-	if !writer.DontGarbageCollect {
-		runtime.GC()
-    }
+
 	if e == nil {
-		e = writer.writePathways(path, feed)
+		e = writer.checkConflicts(feed)
 	}
-	if !writer.DontGarbageCollect {
-		runtime.GC()
-    }
+
 	if e == nil {
-		e = writer.writeAttributions(path, feed, attributions)
+		e = writer.checkShapeDists(feed)
 	}
-	if !writer.DontGarbageCollect {
-		runtime.GC()
-    }
+
+	return feed, attributions, e
+}
+
+// a table is a single GTFS file and the function writing it
+type table struct {
+	name  string
+	write func(path string, feed *gtfsparser.Feed) error
+}
+
+// tables returns all GTFS files in the order they are written,
+// followed by the extra tables
+func (writer *Writer) tables(attributions entAttrs) []table {
+	ret := []table{
+		{"agency.txt", writer.writeAgencies},
+		{"feed_info.txt", writer.writeFeedInfos},
+		{"stops.txt", writer.writeStops},
+		{"shapes.txt", writer.writeShapes},
+		{"routes.txt", writer.writeRoutes},
+		{"calendar.txt", writer.writeCalendar},
+		{"calendar_dates.txt", writer.writeCalendarDates},
+		{"trips.txt", writer.writeTrips},
+		{"stop_times.txt", writer.writeStopTimes},
+		{"fare_attributes.txt", writer.writeFareAttributes},
+		{"fare_rules.txt", writer.writeFareAttributeRules},
+		{"frequencies.txt", writer.writeFrequencies},
+		{"transfers.txt", writer.writeTransfers},
+		{"levels.txt", writer.writeLevels},
+		{"pathways.txt", writer.writePathways},
+		{"attributions.txt", func(path string, feed *gtfsparser.Feed) error {
+			return writer.writeAttributions(path, feed, attributions)
+		}},
+	}
+
+	for _, t := range writer.extraTables {
+		ret = append(ret, table{t.name, writer.writeExtraTable(t)})
+	}
+
+	return ret
+}
+
+// WriteFile writes a single GTFS file of a feed, e.g. "stop_times.txt",
+// to out. All write-time transformations and checks are applied as
+// in Write. If the feed has no entities for the file, only its header
+// is written.
+func (writer *Writer) WriteFile(feed *gtfsparser.Feed, name string, out io.Writer) error {
+	feed, attributions, e := writer.prepare(feed)
 
 	if e != nil {
 		return e
 	}
 
-	if writer.curFileHandle != nil {
-		writer.curFileHandle.Close()
-	}
-	if writer.zipFile != nil {
-		e = writer.zipFile.Close()
+	return writer.writeTable(feed, attributions, name, out)
+}
+
+// writeTable writes a single GTFS file to out, the writer must have
+// been prepared for the feed
+func (writer *Writer) writeTable(feed *gtfsparser.Feed, attributions entAttrs, name string, out io.Writer) error {
+	for _, t := range writer.tables(attributions) {
+		if t.name != name {
+			continue
+		}
+
+		writer.single = out
+		partial := writer.PartialFeed
+		writer.PartialFeed = PartialStubs
+
+		defer func() {
+			writer.single = nil
+			writer.PartialFeed = partial
+		}()
+
+		return t.write("", feed)
 	}
 
-	return e
+	return writeError{name, "unknown GTFS file"}
 }
 
 func (writer *Writer) delExistingFile(path string, name string) error {
-	fileInfo, err := os.Stat(path)
+	if writer.single != nil || writer.extZip != nil {
+		return nil
+	}
+
+	fileInfo, err := writer.backend().Stat(path)
 
 	if err != nil {
 		return err
 	}
 
 	if fileInfo.IsDir() {
-		if _, err := os.Stat(opath.Join(path, name)); err == nil {
-			err := os.Remove(opath.Join(path, name))
+		if _, err := writer.backend().Stat(opath.Join(path, writer.fileName(name))); err == nil {
+			err := writer.backend().Remove(opath.Join(path, writer.fileName(name)))
 			if err != nil {
 				return err
 			}
@@ -180,62 +559,230 @@ func (writer *Writer) delExistingFile(path string, name string) error {
 }
 
 func (writer *Writer) getFileForWriting(path string, name string) (io.Writer, error) {
-	fileInfo, err := os.Stat(path)
+	if writer.single != nil {
+		writer.startDeadline()
+		return writer.fileStats(writer.withThrottle(writer.withFileTimeout(writer.single)), name), nil
+	}
+
+	if writer.extZip != nil {
+		return writer.zipEntry(writer.extZip, name, writer.zipPrefix+name)
+	}
+
+	fileInfo, err := writer.backend().Stat(path)
 
 	if err != nil {
 		return nil, err
 	}
 
 	if fileInfo.IsDir() {
-		if writer.curFileHandle != nil {
-			// close previous handle
-			writer.curFileHandle.Close()
+		// close previous handle
+		if err := writer.closeFile(); err != nil {
+			return nil, err
+		}
+
+		writer.startDeadline()
+
+		var f io.WriteCloser
+		var unchanged *unchangedFile
+		if writer.skipUnchanged() {
+			// written on close, if changed
+			unchanged = &unchangedFile{writer: writer, name: opath.Join(path, writer.fileName(name))}
+			f = unchanged
+		} else {
+			err := writer.withDeadline(func() (err error) {
+				f, err = writer.backend().Create(opath.Join(path, writer.fileName(name)))
+				return err
+			})
+			if err != nil {
+				writer.sinkErr = err
+				return nil, err
+			}
+			writer.created = append(writer.created, opath.Join(path, writer.fileName(name)))
+		}
+
+		writer.curFileHandle = f
+		writer.curFileName = name
+
+		var out io.Writer
+		if writer.GzipFiles {
+			gz, err := writer.newGzipFile(f, writer.withThrottle(writer.withFileTimeout(f)))
+			if err != nil {
+				return nil, err
+			}
+			writer.curFileHandle = gz
+			out = writer.fileStats(gz, name)
+		} else {
+			out = writer.fileStats(writer.withThrottle(writer.withFileTimeout(f)), name)
+		}
+
+		if unchanged != nil {
+			unchanged.stats = writer.Report.Files[len(writer.Report.Files)-1]
 		}
 
-		return os.Create(opath.Join(path, name))
+		return out, nil
 	}
 
 	// ZIP Archive
 	if writer.zipFile == nil {
-		zipF, err := os.Create(path)
+		zipF, err := writer.backend().Create(path)
 		if err != nil {
+			writer.sinkErr = err
 			return nil, err
 		}
-		writer.zipFile = zip.NewWriter(zipF)
+		writer.zipHandle = zipF
+		writer.created = append(writer.created, path)
+		writer.zipFile, err = writer.newZipWriter(writer.withThrottle(writer.withFileTimeout(writer.hashArchive(zipF))))
+		if err != nil {
+			return nil, err
+		}
+	}
 
-		if writer.ZipCompressionLevel == 0 {
-			writer.zipFile.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
-				return flate.NewWriter(out, flate.DefaultCompression)
-			})
-		} else if writer.ZipCompressionLevel == -1 {
-			writer.zipFile.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
-				return flate.NewWriter(out, flate.NoCompression)
-			})
-		} else if writer.ZipCompressionLevel > 0 {
-			writer.zipFile.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
-				return flate.NewWriter(out, writer.ZipCompressionLevel)
-			})
+	return writer.zipEntry(writer.zipFile, name, name)
+}
+
+// newZipWriter returns a ZIP writer writing to out, with the
+// compression and comment of the writer's settings
+func (writer *Writer) newZipWriter(out io.Writer) (*zip.Writer, error) {
+	zw := zip.NewWriter(out)
+
+	if writer.ZipCompressionLevel == 0 {
+		zw.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(out, flate.DefaultCompression)
+		})
+	} else if writer.ZipCompressionLevel == -1 {
+		zw.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(out, flate.NoCompression)
+		})
+	} else if writer.ZipCompressionLevel > 0 {
+		zw.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(out, writer.ZipCompressionLevel)
+		})
+	}
+
+	if writer.Compression == CompressZstd {
+		registerZstd(zw, writer.ZipCompressionLevel)
+	}
+
+	if len(writer.ZipComment) > 0 {
+		if err := zw.SetComment(writer.ZipComment); err != nil {
+			return nil, err
+		}
+	}
+
+	return zw, nil
+}
+
+// zipEntry starts a file called entry in a ZIP file
+func (writer *Writer) zipEntry(zw *zip.Writer, name string, entry string) (io.Writer, error) {
+	writer.startDeadline()
+
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: entry, Method: writer.zipMethod(), Modified: writer.ZipModified})
+	if err != nil {
+		writer.sinkErr = err
+		return nil, err
+	}
+
+	return writer.fileStats(f, name), nil
+}
+
+// closeFile closes the file currently written to a directory. A
+// failure to close it, e.g. on a network filesystem, is reported for
+// that file.
+func (writer *Writer) closeFile() error {
+	if writer.curFileHandle == nil {
+		return nil
+	}
+
+	f := writer.curFileHandle
+	writer.curFileHandle = nil
+
+	if writer.Durable {
+		if e := writer.withDeadline(func() error { return syncFile(f) }); e != nil {
+			f.Close()
+			writer.sinkErr = e
+			return writeError{writer.curFileName, e.Error()}
 		}
 	}
-	return writer.zipFile.Create(name)
+
+	if e := writer.withDeadline(f.Close); e != nil {
+		writer.sinkErr = e
+		return writeError{writer.curFileName, e.Error()}
+	}
+
+	return nil
+}
+
+// closeZip finishes the ZIP file written to path and closes it
+func (writer *Writer) closeZip(path string) error {
+	if writer.zipHandle == nil {
+		return nil
+	}
+
+	// finishing the ZIP file gets its own deadline
+	writer.startDeadline()
+
+	e := writer.zipFile.Close()
+	if e == nil && writer.Durable {
+		e = syncFile(writer.zipHandle)
+	}
+	if ce := writer.zipHandle.Close(); e == nil {
+		e = ce
+	}
+	writer.zipHandle = nil
+
+	if e != nil {
+		return writeError{path, e.Error()}
+	}
+
+	return nil
+}
+
+// fileStats adds a FileStats entry for a file to the report, and
+// returns a writer collecting its statistics
+func (writer *Writer) fileStats(w io.Writer, name string) io.Writer {
+	fs := &FileStats{Name: name}
+	if writer.checksums() {
+		fs.sum = sha256.New()
+	}
+	writer.Report.Files = append(writer.Report.Files, fs)
+	return newStatsWriter(w, writer.Report.Files[len(writer.Report.Files)-1], &writer.sinkErr)
 }
 
-func (writer *Writer) writeAgencies(path string, feed *gtfsparser.Feed, attrs *[]EntAttr) (err error) {
+// newCsvWriter returns a CsvWriter for the GTFS file name, with the
+// quote policy, column pruning and row hook of the writer
+func (writer *Writer) newCsvWriter(file io.Writer, name string) CsvWriter {
+	csvwriter := NewCsvWriter(file)
+	csvwriter.SetQuote(writer.Quoting)
+	csvwriter.SetKeepAll(writer.KeepAllColumns)
+	csvwriter.SetNaturalSort(writer.NaturalSort)
+	if hook := writer.RowHook; hook != nil {
+		csvwriter.SetRowHook(func(header []string, row []string) []string {
+			return hook(name, header, row)
+		})
+	}
+	return csvwriter
+}
+
+func (writer *Writer) writeAgencies(path string, feed *gtfsparser.Feed) (err error) {
+	if writer.PartialFeed == PartialSkip && len(feed.Agencies) == 0 {
+		return writer.delExistingFile(path, "agency.txt")
+	}
+
 	file, e := writer.getFileForWriting(path, "agency.txt")
 
 	if e != nil {
 		return errors.New("Could not open required file agency.txt for writing")
 	}
 
-	csvwriter := NewCsvWriter(file)
+	csvwriter := writer.newCsvWriter(file, "agency.txt")
 
 	defer func() {
 		if r := recover(); r != nil {
-			err = writeError{"agency.txt", r.(error).Error()}
+			err = writer.recovered("agency.txt", r)
 		}
 	}()
 
-	header := []string{"agency_id", "agency_name", "agency_url", "agency_timezone", "agency_lang", "agency_phone", "agency_fare_url", "agency_email"}
+	header := AgencyColumns()
 
 	addFieldsOrder := make([]string, 0)
 
@@ -252,26 +799,7 @@ func (writer *Writer) writeAgencies(path string, feed *gtfsparser.Feed, attrs *[
 	}
 
 	for _, v := range feed.Agencies {
-		fareurl := ""
-		if v.Fare_url != nil {
-			fareurl = v.Fare_url.String()
-		}
-
-		for _, attr := range v.Attributions {
-			*attrs = append(*attrs, EntAttr{attr, nil, v, nil})
-		}
-
-		url := ""
-		if v.Url != nil {
-			url = v.Url.String()
-		}
-
-		email := ""
-		if v.Email != nil {
-			email = v.Email.Address
-		}
-
-		row := []string{v.Id, strings.Replace(v.Name, "\n", " ", -1), url, v.Timezone.GetTzString(), v.Lang.GetLangString(), v.Phone, fareurl, email}
+		row := writer.AgencyRow(v)
 
 		for _, name := range addFieldsOrder {
 			if vald, ok := feed.AgenciesAddFlds[name][v.Id]; ok {
@@ -284,17 +812,17 @@ func (writer *Writer) writeAgencies(path string, feed *gtfsparser.Feed, attrs *[
 		csvwriter.WriteCsvLine(row)
 	}
 
-	if writer.Sorted {
-		csvwriter.SortByCols(1)
-	}
+	writer.sortLines(&csvwriter, "agency.txt", 1)
 
-	csvwriter.Flush()
+	if fe := csvwriter.Flush(); fe != nil {
+		return writeError{"agency.txt", fe.Error()}
+	}
 
 	return e
 }
 
 func (writer *Writer) writeFeedInfos(path string, feed *gtfsparser.Feed) (err error) {
-	if len(feed.FeedInfos) == 0 {
+	if len(feed.FeedInfos) == 0 && writer.PartialFeed != PartialStubs {
 		return writer.delExistingFile(path, "feed_info.txt")
 	}
 	file, e := writer.getFileForWriting(path, "feed_info.txt")
@@ -303,11 +831,11 @@ func (writer *Writer) writeFeedInfos(path string, feed *gtfsparser.Feed) (err er
 		return errors.New("Could not open required file feed_info.txt for writing")
 	}
 
-	csvwriter := NewCsvWriter(file)
+	csvwriter := writer.newCsvWriter(file, "feed_info.txt")
 
 	defer func() {
 		if r := recover(); r != nil {
-			err = writeError{"feed_info.txt", r.(error).Error()}
+			err = writer.recovered("feed_info.txt", r)
 		}
 	}()
 
@@ -343,7 +871,7 @@ func (writer *Writer) writeFeedInfos(path string, feed *gtfsparser.Feed) (err er
 			contactemail = v.Contact_email.Address
 		}
 
-		row := []string{strings.Replace(v.Publisher_name, "\n", " ", -1), puburl, v.Lang, dateToString(v.Start_date), dateToString(v.End_date), strings.Replace(v.Version, "\n", " ", -1), contactemail, contacturl}
+		row := []string{writer.oneLine(v.Publisher_name), puburl, v.Lang, dateToString(v.Start_date), dateToString(v.End_date), writer.oneLine(v.Version), contactemail, contacturl}
 
 		for _, name := range addFieldsOrder {
 			if vald, ok := feed.FeedInfosAddFlds[name][v]; ok {
@@ -356,27 +884,34 @@ func (writer *Writer) writeFeedInfos(path string, feed *gtfsparser.Feed) (err er
 		csvwriter.WriteCsvLine(row)
 	}
 
-	csvwriter.Flush()
+	writer.sortLines(&csvwriter, "feed_info.txt", 0)
+	if fe := csvwriter.Flush(); fe != nil {
+		return writeError{"feed_info.txt", fe.Error()}
+	}
 
 	return e
 }
 
 func (writer *Writer) writeStops(path string, feed *gtfsparser.Feed) (err error) {
+	if writer.PartialFeed == PartialSkip && len(feed.Stops) == 0 && len(writer.genStops) == 0 {
+		return writer.delExistingFile(path, "stops.txt")
+	}
+
 	file, e := writer.getFileForWriting(path, "stops.txt")
 
 	if e != nil {
 		return errors.New("Could not open required file stops.txt for writing")
 	}
 
-	csvwriter := NewCsvWriter(file)
+	csvwriter := writer.newCsvWriter(file, "stops.txt")
 
 	defer func() {
 		if r := recover(); r != nil {
-			err = writeError{"stops.txt", r.(error).Error()}
+			err = writer.recovered("stops.txt", r)
 		}
 	}()
 
-	header := []string{"stop_name", "parent_station", "stop_code", "zone_id", "stop_id", "stop_desc", "stop_lat", "stop_lon", "stop_url", "location_type", "stop_timezone", "wheelchair_boarding", "level_id", "platform_code"}
+	header := StopColumns()
 
 	addFieldsOrder := make([]string, 0)
 
@@ -392,37 +927,23 @@ func (writer *Writer) writeStops(path string, feed *gtfsparser.Feed) (err error)
 		csvwriter.SetOrder(feed.ColOrders.Stops)
 	}
 
+	stops := make([]*gtfs.Stop, 0, len(feed.Stops)+len(writer.genStops))
 	for _, v := range feed.Stops {
-		locType := int(v.Location_type)
-		if locType == 0 {
-			// dont print locType 0
-			locType = -1
-		}
-		wb := v.Wheelchair_boarding
-		if wb == 0 {
-			wb = -1
-		}
-		parentStID := ""
-		if v.Parent_station != nil {
-			parentStID = v.Parent_station.Id
-		}
-		url := ""
-		if v.Url != nil {
-			url = v.Url.String()
-		}
-		levelId := ""
-		if v.Level != nil {
-			levelId = v.Level.Id
-		}
+		stops = append(stops, v)
+	}
+	stops = append(stops, writer.genStops...)
 
-		row := make([]string, 0)
+	for _, v := range stops {
+		if writer.excl.stops[v] || writer.merged(v) {
+			continue
+		}
 
-		if v.HasLatLon() {
-			row = []string{strings.Replace(v.Name, "\n", " ", -1), parentStID, v.Code, v.Zone_id, v.Id, strings.Replace(v.Desc, "\n", " ", -1), writer.formatFloat(v.Lat), writer.formatFloat(v.Lon), url, posIntToString(locType), v.Timezone.GetTzString(), posIntToString(int(wb)), levelId, v.Platform_code}
-		} else {
-			row = []string{strings.Replace(v.Name, "\n", " ", -1), parentStID, v.Code, v.Zone_id, v.Id, strings.Replace(v.Desc, "\n", " ", -1), "", "", url, posIntToString(locType), v.Timezone.GetTzString(), posIntToString(int(wb)), levelId, v.Platform_code}
+		if desc := writer.desc(v.Desc); desc != v.Desc {
+			writer.change("stops.txt", v.Id, "stop_desc", ChangeModified, v.Desc, desc)
 		}
 
+		row := writer.StopRow(v)
+
 		for _, name := range addFieldsOrder {
 			if vald, ok := feed.StopsAddFlds[name][v.Id]; ok {
 				row = append(row, vald)
@@ -434,10 +955,10 @@ func (writer *Writer) writeStops(path string, feed *gtfsparser.Feed) (err error)
 		csvwriter.WriteCsvLine(row)
 	}
 
-	if writer.Sorted {
-		csvwriter.SortByCols(12)
+	writer.sortLines(&csvwriter, "stops.txt", 12)
+	if fe := csvwriter.Flush(); fe != nil {
+		return writeError{"stops.txt", fe.Error()}
 	}
-	csvwriter.Flush()
 
 	return e
 }
@@ -460,21 +981,33 @@ func (writer *Writer) formatFloat(f float32) string {
 	return string(writer.buff)
 }
 
+// formatFloat formats a float like Writer.formatFloat, for use without
+// a writer
+func formatFloat(f float32) string {
+	var arr [32]byte
+	return string(strconv.AppendFloat(arr[:0], float64(f), 'f', -1, 32))
+}
+
 func (writer *Writer) shapePointLine(v *gtfs.Shape, vp *gtfs.ShapePoint, ret []string) {
+	shapePointLine(v, vp, ret, writer.rowOptions())
+}
+
+// shapePointLine fills ret with the values of a point of shape v
+func shapePointLine(v *gtfs.Shape, vp *gtfs.ShapePoint, ret []string, o RowOptions) {
 	distTrav := ""
 	if vp.HasDistanceTraveled() {
-		distTrav = writer.formatFloat(vp.Dist_traveled)
+		distTrav = o.formatDist(vp.Dist_traveled)
 	}
 
 	ret[0] = v.Id
-	ret[1] = writer.formatFloat(vp.Lat)
-	ret[2] = writer.formatFloat(vp.Lon)
+	ret[1] = formatFloat(vp.Lat)
+	ret[2] = formatFloat(vp.Lon)
 	ret[3] = posIntToString(int(vp.Sequence))
 	ret[4] = distTrav
 }
 
 func (writer *Writer) writeShapes(path string, feed *gtfsparser.Feed) (err error) {
-	if len(feed.Shapes) == 0 {
+	if len(feed.Shapes) == 0 && len(writer.genShapes) == 0 && (writer.streamed == nil || writer.streamed.shapes.rows == 0) && writer.PartialFeed != PartialStubs {
 		return writer.delExistingFile(path, "shapes.txt")
 	}
 	file, e := writer.getFileForWriting(path, "shapes.txt")
@@ -483,15 +1016,15 @@ func (writer *Writer) writeShapes(path string, feed *gtfsparser.Feed) (err error
 		return errors.New("Could not open required file shapes.txt for writing")
 	}
 
-	csvwriter := NewCsvWriter(file)
+	csvwriter := writer.newCsvWriter(file, "shapes.txt")
 
 	defer func() {
 		if r := recover(); r != nil {
-			err = writeError{"shapes.txt", r.(error).Error()}
+			err = writer.recovered("shapes.txt", r)
 		}
 	}()
 
-	header := []string{"shape_id", "shape_pt_lat", "shape_pt_lon", "shape_pt_sequence", "shape_dist_traveled"}
+	header := ShapePointColumns()
 
 	addFieldsOrder := make([]string, 0)
 
@@ -508,19 +1041,41 @@ func (writer *Writer) writeShapes(path string, feed *gtfsparser.Feed) (err error
 		csvwriter.SetOrder(feed.ColOrders.Shapes)
 	}
 
-	lines := make(shapeLines, len(feed.Shapes))
-	i := 0
-
-	row := make([]string, 5+len(feed.ShapesAddFlds))
+	lines := make(shapeLines, 0, len(feed.Shapes)+len(writer.genShapes))
 
 	for _, v := range feed.Shapes {
-		lines[i] = shapeLine{v}
+		lines = append(lines, shapeLine{v})
+	}
 
-		i += 1
+	for _, v := range writer.genShapes {
+		lines = append(lines, shapeLine{v})
+	}
+
+	row := make([]string, 5+len(feed.ShapesAddFlds))
 
+	// the row hook is applied once per line, the hooked lines are
+	// kept for writing
+	var hookedRows map[*gtfs.Shape][][]string
+	if csvwriter.hasHook() {
+		hookedRows = make(map[*gtfs.Shape][][]string, len(lines))
+	}
+
+	for n, l := range lines {
+		if ce := writer.checkCanceled("shapes.txt", n); ce != nil {
+			return ce
+		}
+		v := l.Shape
 		for _, vp := range v.Points {
 			writer.shapePointLine(v, &vp, row)
 
+			if hookedRows != nil {
+				writer.shapePointAddFields(feed, addFieldsOrder, v, &vp, row)
+				hooked := append([]string(nil), csvwriter.hooked(row)...)
+				hookedRows[v] = append(hookedRows[v], hooked)
+				csvwriter.HeaderUsage(hooked)
+				continue
+			}
+
 			// fill them with dummy values to make sure they count as non-empty
 			for i := 0; i < len(feed.ShapesAddFlds); i++ {
 				row[5+i] = "-"
@@ -529,50 +1084,112 @@ func (writer *Writer) writeShapes(path string, feed *gtfsparser.Feed) (err error
 		}
 	}
 
-	if writer.Sorted {
-		sort.Sort(lines)
+	// additional fields count as non-empty
+	if len(hookedRows) > 0 {
+		csvwriter.HeaderUsage(addFieldsUsage(len(row), 5, len(feed.ShapesAddFlds)))
+	}
+
+	if writer.streamed != nil {
+		writer.streamed.shapes.headerUsage(&csvwriter)
+	}
+
+	keyed := writer.keyed("shapes.txt")
+
+	if writer.Sorted && !keyed {
+		writer.sortShapes(lines)
+	}
+
+	if !keyed {
+		csvwriter.WriteHeader()
 	}
 
-	csvwriter.WriteHeader()
+	for n, v := range lines {
+		if ce := writer.checkCanceled("shapes.txt", n); ce != nil {
+			return ce
+		}
+		if hookedRows != nil {
+			for _, hooked := range hookedRows[v.Shape] {
+				csvwriter.writeHooked(hooked, keyed)
+			}
+			continue
+		}
 
-	for _, v := range lines {
 		for _, vp := range v.Shape.Points {
 			writer.shapePointLine(v.Shape, &vp, row)
+			writer.shapePointAddFields(feed, addFieldsOrder, v.Shape, &vp, row)
 
-			// additional fields
-			for i, name := range addFieldsOrder {
-				if vald, ok := feed.ShapesAddFlds[name][v.Shape.Id][int(vp.Sequence)]; ok {
-					row[5+i] = vald
-				} else {
-					row[5+i] = ""
-				}
+			if keyed {
+				csvwriter.WriteCsvLine(append([]string(nil), row...))
+			} else {
+				csvwriter.WriteCsvLineRaw(row)
 			}
+		}
+	}
 
-			csvwriter.WriteCsvLineRaw(row)
+	// points streamed by a builder
+	if writer.streamed != nil {
+		if ce := writer.streamed.shapes.copy(&csvwriter, len(row), keyed); ce != nil {
+			return writeError{"shapes.txt", ce.Error()}
 		}
 	}
 
-	csvwriter.FlushFile()
+	if keyed {
+		writer.sortLines(&csvwriter, "shapes.txt", 0)
+		if fe := csvwriter.Flush(); fe != nil {
+			return writeError{"shapes.txt", fe.Error()}
+		}
+		return e
+	}
+
+	if fe := csvwriter.FlushFile(); fe != nil {
+		return writeError{"shapes.txt", fe.Error()}
+	}
 
 	return e
 }
 
-func (writer *Writer) writeRoutes(path string, feed *gtfsparser.Feed, attrs *[]EntAttr) (err error) {
+// shapePointAddFields fills the additional fields of a shape point
+// into row
+func (writer *Writer) shapePointAddFields(feed *gtfsparser.Feed, addFieldsOrder []string, shape *gtfs.Shape, p *gtfs.ShapePoint, row []string) {
+	for i, name := range addFieldsOrder {
+		if vald, ok := feed.ShapesAddFlds[name][shape.Id][int(p.Sequence)]; ok {
+			row[5+i] = vald
+		} else {
+			row[5+i] = ""
+		}
+	}
+}
+
+// addFieldsUsage returns a line of the given length whose n additional
+// fields starting at column start are non-empty
+func addFieldsUsage(length int, start int, n int) []string {
+	ret := make([]string, length)
+	for i := start; i < start+n; i++ {
+		ret[i] = "-"
+	}
+	return ret
+}
+
+func (writer *Writer) writeRoutes(path string, feed *gtfsparser.Feed) (err error) {
+	if writer.PartialFeed == PartialSkip && len(feed.Routes) == 0 {
+		return writer.delExistingFile(path, "routes.txt")
+	}
+
 	file, e := writer.getFileForWriting(path, "routes.txt")
 
 	if e != nil {
 		return errors.New("Could not open required file routes.txt for writing")
 	}
 
-	csvwriter := NewCsvWriter(file)
+	csvwriter := writer.newCsvWriter(file, "routes.txt")
 
 	defer func() {
 		if r := recover(); r != nil {
-			err = writeError{"routes.txt", r.(error).Error()}
+			err = writer.recovered("routes.txt", r)
 		}
 	}()
 
-	header := []string{"route_long_name", "route_short_name", "agency_id", "route_desc", "route_type", "route_id", "route_url", "route_color", "route_text_color", "route_sort_order", "continuous_pickup", "continuous_drop_off"}
+	header := RouteColumns()
 
 	addFieldsOrder := make([]string, 0)
 
@@ -590,37 +1207,15 @@ func (writer *Writer) writeRoutes(path string, feed *gtfsparser.Feed, attrs *[]E
 	}
 
 	for _, r := range feed.Routes {
-		agency := ""
-		if r.Agency != nil {
-			agency = r.Agency.Id
-		}
-
-		for _, attr := range r.Attributions {
-			*attrs = append(*attrs, EntAttr{attr, r, nil, nil})
+		if _, merged := writer.routeMap[r]; merged {
+			continue
 		}
 
-		color := r.Color
-		if color == "FFFFFF" {
-			color = ""
-		}
-		textColor := r.Text_color
-		if textColor == "000000" {
-			textColor = ""
-		}
-		url := ""
-		if r.Url != nil {
-			url = r.Url.String()
-		}
-		contPickup := int(r.Continuous_pickup)
-		if contPickup == 1 {
-			contPickup = -1
-		}
-		contDropOff := int(r.Continuous_drop_off)
-		if contDropOff == 1 {
-			contDropOff = -1
+		if desc := writer.desc(r.Desc); desc != r.Desc {
+			writer.change("routes.txt", r.Id, "route_desc", ChangeModified, r.Desc, desc)
 		}
 
-		row := []string{strings.Replace(r.Long_name, "\n", " ", -1), strings.Replace(r.Short_name, "\n", " ", -1), agency, strings.Replace(r.Desc, "\n", " ", -1), posIntToString(int(r.Type)), r.Id, url, color, textColor, posIntToString(r.Sort_order), posIntToString(contPickup), posIntToString(contDropOff)}
+		row := writer.RouteRow(r)
 
 		for _, name := range addFieldsOrder {
 			if vald, ok := feed.RoutesAddFlds[name][r.Id]; ok {
@@ -633,10 +1228,10 @@ func (writer *Writer) writeRoutes(path string, feed *gtfsparser.Feed, attrs *[]E
 		csvwriter.WriteCsvLine(row)
 	}
 
-	if writer.Sorted {
-		csvwriter.SortByCols(9)
+	writer.sortLines(&csvwriter, "routes.txt", 9)
+	if fe := csvwriter.Flush(); fe != nil {
+		return writeError{"routes.txt", fe.Error()}
 	}
-	csvwriter.Flush()
 
 	return e
 }
@@ -644,12 +1239,12 @@ func (writer *Writer) writeRoutes(path string, feed *gtfsparser.Feed, attrs *[]E
 func (writer *Writer) writeCalendar(path string, feed *gtfsparser.Feed) (err error) {
 	hasCalendarEntries := false
 	for _, v := range feed.Services {
-		if v.RawDaymap() > 0 || v.IsEmpty() {
+		if writer.calendarRow(v) {
 			hasCalendarEntries = true
 			break
 		}
 	}
-	if !hasCalendarEntries && !writer.ExplicitCalendar {
+	if !hasCalendarEntries && !writer.ExplicitCalendar && writer.PartialFeed != PartialStubs {
 		return writer.delExistingFile(path, "calendar.txt")
 	}
 	file, e := writer.getFileForWriting(path, "calendar.txt")
@@ -658,34 +1253,33 @@ func (writer *Writer) writeCalendar(path string, feed *gtfsparser.Feed) (err err
 		return errors.New("Could not open required file calendar.txt for writing")
 	}
 
-	csvwriter := NewCsvWriter(file)
+	csvwriter := writer.newCsvWriter(file, "calendar.txt")
 
 	defer func() {
 		if r := recover(); r != nil {
-			err = writeError{"calendar.txt", r.(error).Error()}
+			err = writer.recovered("calendar.txt", r)
 		}
 	}()
 
 	// write header
-	csvwriter.SetHeader([]string{"monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday", "start_date", "end_date", "service_id"},
-	[]string{"monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday", "start_date", "end_date", "service_id"})
+	csvwriter.SetHeader(CalendarColumns(), CalendarColumns())
 
 	if writer.KeepColOrder {
 		csvwriter.SetOrder(feed.ColOrders.Calendar)
 	}
 
 	for _, v := range feed.Services {
-		if v.RawDaymap() > 0 || v.IsEmpty() {
-			csvwriter.WriteCsvLine([]string{boolToGtfsBool(v.Daymap(1), true), boolToGtfsBool(v.Daymap(2), true), boolToGtfsBool(v.Daymap(3), true), boolToGtfsBool(v.Daymap(4), true), boolToGtfsBool(v.Daymap(5), true), boolToGtfsBool(v.Daymap(6), true), boolToGtfsBool(v.Daymap(0), true), dateToString(v.Start_date()), dateToString(v.End_date()), v.Id()})
+		if writer.calendarRow(v) {
+			csvwriter.WriteCsvLine(writer.CalendarRow(v))
 		} else if writer.ExplicitCalendar {
 			csvwriter.WriteCsvLine([]string{"0", "0", "0", "0", "0", "0", "0", dateToString(v.GetFirstDefinedDate()), dateToString(v.GetLastDefinedDate()), v.Id()})
 		}
 	}
 
-	if writer.Sorted {
-		csvwriter.SortByCols(10)
+	writer.sortLines(&csvwriter, "calendar.txt", 10)
+	if fe := csvwriter.Flush(); fe != nil {
+		return writeError{"calendar.txt", fe.Error()}
 	}
-	csvwriter.Flush()
 
 	return e
 }
@@ -693,12 +1287,12 @@ func (writer *Writer) writeCalendar(path string, feed *gtfsparser.Feed) (err err
 func (writer *Writer) writeCalendarDates(path string, feed *gtfsparser.Feed) (err error) {
 	hasCalendarDatesEntries := false
 	for _, v := range feed.Services {
-		if len(v.Exceptions()) > 0 {
+		if len(v.Exceptions()) > 0 || writer.datesOnly[v] {
 			hasCalendarDatesEntries = true
 			break
 		}
 	}
-	if !hasCalendarDatesEntries {
+	if !hasCalendarDatesEntries && writer.PartialFeed != PartialStubs {
 		return writer.delExistingFile(path, "calendar_dates.txt")
 	}
 	file, e := writer.getFileForWriting(path, "calendar_dates.txt")
@@ -707,11 +1301,11 @@ func (writer *Writer) writeCalendarDates(path string, feed *gtfsparser.Feed) (er
 		return errors.New("Could not open required file calendar_dates.txt for writing")
 	}
 
-	csvwriter := NewCsvWriter(file)
+	csvwriter := writer.newCsvWriter(file, "calendar_dates.txt")
 
 	defer func() {
 		if r := recover(); r != nil {
-			err = writeError{"calendar_dates.txt", r.(error).Error()}
+			err = writer.recovered("calendar_dates.txt", r)
 		}
 	}()
 
@@ -723,6 +1317,12 @@ func (writer *Writer) writeCalendarDates(path string, feed *gtfsparser.Feed) (er
 	}
 
 	for _, v := range feed.Services {
+		if writer.datesOnly[v] {
+			for _, d := range activeDates(v) {
+				csvwriter.WriteCsvLine([]string{v.Id(), "1", dateToString(d)})
+			}
+			continue
+		}
 		for d, traw := range v.Exceptions() {
 			t := int8(1)
 			if !traw {
@@ -732,30 +1332,34 @@ func (writer *Writer) writeCalendarDates(path string, feed *gtfsparser.Feed) (er
 		}
 	}
 
-	if writer.Sorted {
-		csvwriter.SortByCols(3)
+	writer.sortLines(&csvwriter, "calendar_dates.txt", 3)
+	if fe := csvwriter.Flush(); fe != nil {
+		return writeError{"calendar_dates.txt", fe.Error()}
 	}
-	csvwriter.Flush()
 
 	return e
 }
 
-func (writer *Writer) writeTrips(path string, feed *gtfsparser.Feed, attrs *[]EntAttr) (err error) {
+func (writer *Writer) writeTrips(path string, feed *gtfsparser.Feed) (err error) {
+	if writer.PartialFeed == PartialSkip && len(feed.Trips) == 0 {
+		return writer.delExistingFile(path, "trips.txt")
+	}
+
 	file, e := writer.getFileForWriting(path, "trips.txt")
 
 	if e != nil {
 		return errors.New("Could not open required file trips.txt for writing")
 	}
 
-	csvwriter := NewCsvWriter(file)
+	csvwriter := writer.newCsvWriter(file, "trips.txt")
 
 	defer func() {
 		if r := recover(); r != nil {
-			err = writeError{"trips.txt", r.(error).Error()}
+			err = writer.recovered("trips.txt", r)
 		}
 	}()
 
-	header := []string{"route_id", "service_id", "trip_headsign", "trip_short_name", "direction_id", "block_id", "shape_id", "trip_id", "wheelchair_accessible", "bikes_allowed"}
+	header := TripColumns()
 
 	addFieldsOrder := make([]string, 0)
 
@@ -772,38 +1376,11 @@ func (writer *Writer) writeTrips(path string, feed *gtfsparser.Feed, attrs *[]En
 	}
 
 	for _, t := range feed.Trips {
-		wa := int(t.Wheelchair_accessible)
-		if wa == 0 {
-			wa = -1
-		}
-		if t.Attributions != nil {
-			for _, attr := range *t.Attributions {
-				*attrs = append(*attrs, EntAttr{attr, nil, nil, t})
-			}
-		}
-		ba := int(t.Bikes_allowed)
-		if ba == 0 {
-			ba = -1
-		}
-
-		row := make([]string, 0)
-
-		blockid := ""
-		shortname := ""
-
-		if t.Block_id != nil {
-			blockid = *t.Block_id
+		if ba := writer.bikesAllowed(t); ba != t.Bikes_allowed {
+			writer.change("trips.txt", t.Id, "bikes_allowed", ChangeFilled, "", strconv.Itoa(int(ba)))
 		}
 
-		if t.Short_name != nil {
-			shortname = *t.Short_name
-		}
-
-		if t.Shape == nil {
-			row = []string{t.Route.Id, t.Service.Id(), strings.Replace(*t.Headsign, "\n", " ", -1), strings.Replace(shortname, "\n", " ", -1), posIntToString(int(t.Direction_id)), blockid, "", t.Id, posIntToString(wa), posIntToString(ba)}
-		} else {
-			row = []string{t.Route.Id, t.Service.Id(), strings.Replace(*t.Headsign, "\n", " ", -1), strings.Replace(shortname, "\n", " ", -1), posIntToString(int(t.Direction_id)), blockid, t.Shape.Id, t.Id, posIntToString(wa), posIntToString(ba)}
-		}
+		row := writer.TripRow(t)
 
 		for _, name := range addFieldsOrder {
 			if vald, ok := feed.TripsAddFlds[name][t.Id]; ok {
@@ -816,10 +1393,10 @@ func (writer *Writer) writeTrips(path string, feed *gtfsparser.Feed, attrs *[]En
 		csvwriter.WriteCsvLine(row)
 	}
 
-	if writer.Sorted {
-		csvwriter.SortByCols(10)
+	writer.sortLines(&csvwriter, "trips.txt", 10)
+	if fe := csvwriter.Flush(); fe != nil {
+		return writeError{"trips.txt", fe.Error()}
 	}
-	csvwriter.Flush()
 
 	return e
 }
@@ -841,29 +1418,23 @@ func (tl tripLines) Less(i, j int) bool {
 }
 
 func (writer *Writer) stopTimeLine(v *gtfs.Trip, st *gtfs.StopTime, row []string) {
+	stopTimeLine(writer.tripId(v), writer.stop(st.Stop()).Id, st, row, writer.rowOptions())
+}
+
+// stopTimeLine fills row with the values of a stop time, which belongs
+// to the trip tripId and serves the stop stopId
+func stopTimeLine(tripId string, stopId string, st *gtfs.StopTime, row []string, o RowOptions) {
 	distTrav := ""
 	if st.HasDistanceTraveled() {
-		distTrav = writer.formatFloat(st.Shape_dist_traveled())
-	}
-	puType := int(st.Pickup_type())
-	if puType == 0 {
-		puType = -1
-	}
-	doType := int(st.Drop_off_type())
-	if doType == 0 {
-		doType = -1
-	}
-	contPickup := int(st.Continuous_pickup())
-	if contPickup == 1 {
-		contPickup = -1
-	}
-	contDropOff := int(st.Continuous_drop_off())
-	if contDropOff == 1 {
-		contDropOff = -1
+		distTrav = o.formatDist(st.Shape_dist_traveled())
 	}
+	puType := o.omitDefault(int(st.Pickup_type()), 0)
+	doType := o.omitDefault(int(st.Drop_off_type()), 0)
+	contPickup := o.omitDefault(int(st.Continuous_pickup()), 1)
+	contDropOff := o.omitDefault(int(st.Continuous_drop_off()), 1)
 
-	row[0] = v.Id
-	row[3] = st.Stop().Id
+	row[0] = tripId
+	row[3] = stopId
 	row[4] = posIntToString(st.Sequence())
 	row[5] = *st.Headsign()
 	row[6] = posIntToString(puType)
@@ -880,6 +1451,9 @@ func (writer *Writer) stopTimeLine(v *gtfs.Trip, st *gtfs.StopTime, row []string
 		if st.Timepoint() {
 			row[1] = timeToString(st.Arrival_time())
 			row[2] = timeToString(st.Departure_time())
+			if o.ExplicitDefaults {
+				row[11] = "1"
+			}
 		} else {
 			row[1] = timeToString(st.Arrival_time())
 			row[2] = timeToString(st.Departure_time())
@@ -889,21 +1463,33 @@ func (writer *Writer) stopTimeLine(v *gtfs.Trip, st *gtfs.StopTime, row []string
 }
 
 func (writer *Writer) writeStopTimes(path string, feed *gtfsparser.Feed) (err error) {
+	if writer.streamed != nil {
+		return writer.writeStreamedStopTimes(path, feed)
+	}
+
+	if writer.StreamStopTimes {
+		return writer.writeStopTimesStreaming(path, feed)
+	}
+
+	if writer.PartialFeed == PartialSkip && !hasStopTimes(feed) {
+		return writer.delExistingFile(path, "stop_times.txt")
+	}
+
 	file, e := writer.getFileForWriting(path, "stop_times.txt")
 
 	if e != nil {
 		return errors.New("Could not open required file stop_times.txt for writing")
 	}
 
-	csvwriter := NewCsvWriter(file)
+	csvwriter := writer.newCsvWriter(file, "stop_times.txt")
 
 	defer func() {
 		if r := recover(); r != nil {
-			err = writeError{"stop_times.txt", r.(error).Error()}
+			err = writer.recovered("stop_times.txt", r)
 		}
 	}()
 
-	header := []string{"trip_id", "arrival_time", "departure_time", "stop_id", "stop_sequence", "stop_headsign", "pickup_type", "drop_off_type", "continuous_pickup", "continuous_drop_off", "shape_dist_traveled", "timepoint"}
+	header := StopTimeColumns()
 
 	addFieldsOrder := make([]string, 0)
 
@@ -912,6 +1498,12 @@ func (writer *Writer) writeStopTimes(path string, feed *gtfsparser.Feed) (err er
 		addFieldsOrder = append(addFieldsOrder, k)
 	}
 
+	// seconds columns follow the additional fields
+	secs := len(header)
+	if writer.SecondsColumns {
+		header = append(header, "arrival_secs", "departure_secs")
+	}
+
 	// write header
 	csvwriter.SetHeader(header,
 	[]string{"trip_id", "arrival_time", "departure_time", "stop_id", "stop_sequence"})
@@ -923,15 +1515,50 @@ func (writer *Writer) writeStopTimes(path string, feed *gtfsparser.Feed) (err er
 	lines := make(tripLines, len(feed.Trips))
 	i := 0
 
-	row := make([]string, 12+len(feed.StopTimesAddFlds))
+	row := make([]string, len(header))
+
+	invalid := make([]string, 0)
+
+	// the row hook is applied once per line, the hooked lines are
+	// kept for writing
+	var hookedRows map[*gtfs.Trip][][]string
+	if csvwriter.hasHook() {
+		hookedRows = make(map[*gtfs.Trip][][]string, len(feed.Trips))
+	}
 
 	for _, v := range feed.Trips {
+		if ce := writer.checkCanceled("stop_times.txt", i); ce != nil {
+			return ce
+		}
 		lines[i] = tripLine{v}
 		i += 1
 
+		if writer.StopTimesCheck != CheckOff {
+			if field, msg := stopTimesProblem(v); len(msg) > 0 {
+				writer.Report.InvalidStopTimes = append(writer.Report.InvalidStopTimes, writer.tripId(v))
+				if writer.StopTimesCheck == CheckWarn {
+					writer.warn("stop_times.txt", writer.tripId(v), field, msg)
+				} else {
+					invalid = append(invalid, fmt.Sprintf("'%s' (%s)", writer.tripId(v), msg))
+				}
+			}
+		}
+
 		for _, st := range v.StopTimes {
 			writer.stopTimeLine(v, &st, row)
 
+			if writer.SecondsColumns {
+				stopTimeSeconds(&st, row[secs:])
+			}
+
+			if hookedRows != nil {
+				writer.stopTimeAddFields(feed, addFieldsOrder, v, &st, row)
+				hooked := append([]string(nil), csvwriter.hooked(row)...)
+				hookedRows[v] = append(hookedRows[v], hooked)
+				csvwriter.HeaderUsage(hooked)
+				continue
+			}
+
 			// fill them with dummy values to make sure they count as non-empty
 			for i := 0; i < len(feed.StopTimesAddFlds); i++ {
 				row[12+i] = "-"
@@ -940,50 +1567,102 @@ func (writer *Writer) writeStopTimes(path string, feed *gtfsparser.Feed) (err er
 		}
 	}
 
+	// additional fields count as non-empty
+	if len(hookedRows) > 0 {
+		csvwriter.HeaderUsage(addFieldsUsage(len(row), 12, len(feed.StopTimesAddFlds)))
+	}
+
+	sort.Strings(writer.Report.InvalidStopTimes)
+
+	if len(invalid) > 0 {
+		sort.Strings(invalid)
+		return writeError{"stop_times.txt", "trips with invalid stop times: " + strings.Join(invalid, ", ")}
+	}
+
+	keyed := writer.keyed("stop_times.txt")
+
 	// always keep additional header
-	if writer.Sorted {
-		sort.Sort(lines)
+	if writer.Sorted && !keyed {
+		writer.sortTrips(lines)
 	}
 
-	csvwriter.WriteHeader()
+	if !keyed {
+		csvwriter.WriteHeader()
+	}
+
+	for n, v := range lines {
+		if ce := writer.checkCanceled("stop_times.txt", n); ce != nil {
+			return ce
+		}
+		if hookedRows != nil {
+			for _, hooked := range hookedRows[v.Trip] {
+				csvwriter.writeHooked(hooked, keyed)
+			}
+			continue
+		}
 
-	for _, v := range lines {
 		for _, st := range v.Trip.StopTimes {
 			writer.stopTimeLine(v.Trip, &st, row)
+			writer.stopTimeAddFields(feed, addFieldsOrder, v.Trip, &st, row)
 
-			// additional fields
-			for i, name := range addFieldsOrder {
-				if vald, ok := feed.StopTimesAddFlds[name][v.Trip.Id][st.Sequence()]; ok {
-					row[12+i] = vald
-				} else {
-					row[12+i] = ""
-				}
+			if writer.SecondsColumns {
+				stopTimeSeconds(&st, row[secs:])
 			}
 
-			csvwriter.WriteCsvLineRaw(row)
+			if keyed {
+				csvwriter.WriteCsvLine(append([]string(nil), row...))
+			} else {
+				csvwriter.WriteCsvLineRaw(row)
+			}
 		}
 	}
 
-	csvwriter.FlushFile()
+	if keyed {
+		writer.sortLines(&csvwriter, "stop_times.txt", 0)
+		if fe := csvwriter.Flush(); fe != nil {
+			return writeError{"stop_times.txt", fe.Error()}
+		}
+		return e
+	}
+
+	if fe := csvwriter.FlushFile(); fe != nil {
+		return writeError{"stop_times.txt", fe.Error()}
+	}
 
 	return e
 }
 
+// stopTimeAddFields fills the additional fields of a stop time into row
+func (writer *Writer) stopTimeAddFields(feed *gtfsparser.Feed, addFieldsOrder []string, trip *gtfs.Trip, st *gtfs.StopTime, row []string) {
+	for i, name := range addFieldsOrder {
+		if vald, ok := feed.StopTimesAddFlds[name][trip.Id][st.Sequence()]; ok {
+			row[12+i] = vald
+		} else {
+			row[12+i] = ""
+		}
+	}
+}
+
 func (writer *Writer) writeFareAttributes(path string, feed *gtfsparser.Feed) (err error) {
-	if len(feed.FareAttributes) == 0 {
+	if len(feed.FareAttributes) == 0 && writer.PartialFeed != PartialStubs {
 		return writer.delExistingFile(path, "fare_attributes.txt")
 	}
+
+	if e := writer.checkCurrencies(feed); e != nil {
+		return e
+	}
+
 	file, e := writer.getFileForWriting(path, "fare_attributes.txt")
 
 	if e != nil {
 		return errors.New("Could not open required file fare_attributes.txt for writing")
 	}
 
-	csvwriter := NewCsvWriter(file)
+	csvwriter := writer.newCsvWriter(file, "fare_attributes.txt")
 
 	defer func() {
 		if r := recover(); r != nil {
-			err = writeError{"fare_attributes.txt", r.(error).Error()}
+			err = writer.recovered("fare_attributes.txt", r)
 		}
 	}()
 
@@ -1023,10 +1702,10 @@ func (writer *Writer) writeFareAttributes(path string, feed *gtfsparser.Feed) (e
 		csvwriter.WriteCsvLine(row)
 	}
 
-	if writer.Sorted {
-		csvwriter.SortByCols(1)
+	writer.sortLines(&csvwriter, "fare_attributes.txt", 1)
+	if fe := csvwriter.Flush(); fe != nil {
+		return writeError{"fare_attributes.txt", fe.Error()}
 	}
-	csvwriter.Flush()
 
 	return e
 }
@@ -1039,7 +1718,7 @@ func (writer *Writer) writeFareAttributeRules(path string, feed *gtfsparser.Feed
 			break
 		}
 	}
-	if !hasFareAttrRules {
+	if !hasFareAttrRules && writer.PartialFeed != PartialStubs {
 		return writer.delExistingFile(path, "fare_rules.txt")
 	}
 	file, e := writer.getFileForWriting(path, "fare_rules.txt")
@@ -1048,11 +1727,11 @@ func (writer *Writer) writeFareAttributeRules(path string, feed *gtfsparser.Feed
 		return errors.New("Could not open required file fare_rules.txt for writing")
 	}
 
-	csvwriter := NewCsvWriter(file)
+	csvwriter := writer.newCsvWriter(file, "fare_rules.txt")
 
 	defer func() {
 		if r := recover(); r != nil {
-			err = writeError{"fare_rules.txt", r.(error).Error()}
+			err = writer.recovered("fare_rules.txt", r)
 		}
 	}()
 
@@ -1077,9 +1756,9 @@ func (writer *Writer) writeFareAttributeRules(path string, feed *gtfsparser.Feed
 			row := make([]string, 0)
 
 			if r.Route == nil {
-				row = []string{v.Id, "", r.Origin_id, r.Destination_id, r.Contains_id}
+				row = []string{v.Id, "", writer.zoneId(r.Origin_id), writer.zoneId(r.Destination_id), writer.zoneId(r.Contains_id)}
 			} else {
-				row = []string{v.Id, r.Route.Id, r.Origin_id, r.Destination_id, r.Contains_id}
+				row = []string{v.Id, writer.route(r.Route).Id, writer.zoneId(r.Origin_id), writer.zoneId(r.Destination_id), writer.zoneId(r.Contains_id)}
 			}
 
 			for _, name := range addFieldsOrder {
@@ -1094,10 +1773,16 @@ func (writer *Writer) writeFareAttributeRules(path string, feed *gtfsparser.Feed
 		}
 	}
 
-	if writer.Sorted {
-		csvwriter.SortByCols(5)
+	// rules come in map order, always group them by fare_id and
+	// order them by route, origin, destination and contains ID
+	if writer.keyed("fare_rules.txt") {
+		writer.sortLines(&csvwriter, "fare_rules.txt", 0)
+	} else {
+		csvwriter.SortByCols(len(header))
+	}
+	if fe := csvwriter.Flush(); fe != nil {
+		return writeError{"fare_rules.txt", fe.Error()}
 	}
-	csvwriter.Flush()
 
 	return e
 }
@@ -1113,7 +1798,7 @@ func (writer *Writer) writeFrequencies(path string, feed *gtfsparser.Feed) (err
 			break
 		}
 	}
-	if !hasFrequencies {
+	if !hasFrequencies && writer.PartialFeed != PartialStubs {
 		return writer.delExistingFile(path, "frequencies.txt")
 	}
 	file, e := writer.getFileForWriting(path, "frequencies.txt")
@@ -1122,11 +1807,11 @@ func (writer *Writer) writeFrequencies(path string, feed *gtfsparser.Feed) (err
 		return errors.New("Could not open required file frequencies.txt for writing")
 	}
 
-	csvwriter := NewCsvWriter(file)
+	csvwriter := writer.newCsvWriter(file, "frequencies.txt")
 
 	defer func() {
 		if r := recover(); r != nil {
-			err = writeError{"frequencies.txt", r.(error).Error()}
+			err = writer.recovered("frequencies.txt", r)
 		}
 	}()
 
@@ -1146,20 +1831,28 @@ func (writer *Writer) writeFrequencies(path string, feed *gtfsparser.Feed) (err
 		csvwriter.SetOrder(feed.ColOrders.Frequencies)
 	}
 
-	for _, v := range feed.Trips {
-		if v.Frequencies == nil {
-			continue
+	// always write frequencies ordered by trip and start time
+	tripIds := make([]string, 0)
+	for id, v := range feed.Trips {
+		if v.Frequencies != nil && len(*v.Frequencies) > 0 {
+			tripIds = append(tripIds, id)
 		}
-		for _, f := range *v.Frequencies {
+	}
+	sort.Strings(tripIds)
+
+	for _, id := range tripIds {
+		v := feed.Trips[id]
+		for _, fl := range writer.tripFrequencies(v) {
+			f := fl.freq
 			row := make([]string, 0)
 			if !f.Exact_times {
-				row = []string{v.Id, timeToString(f.Start_time), timeToString(f.End_time), posIntToString(f.Headway_secs), ""}
+				row = []string{writer.tripId(v), timeToString(f.Start_time), timeToString(f.End_time), posIntToString(f.Headway_secs), writer.defaultValue("0")}
 			} else {
-				row = []string{v.Id, timeToString(f.Start_time), timeToString(f.End_time), posIntToString(f.Headway_secs), "1"}
+				row = []string{writer.tripId(v), timeToString(f.Start_time), timeToString(f.End_time), posIntToString(f.Headway_secs), "1"}
 			}
 
 			for _, name := range addFieldsOrder {
-				if vald, ok := feed.FrequenciesAddFlds[name][v.Id][f]; ok {
+				if vald, ok := feed.FrequenciesAddFlds[name][v.Id][fl.orig]; ok {
 					row = append(row, vald)
 				} else {
 					row = append(row, "")
@@ -1170,16 +1863,16 @@ func (writer *Writer) writeFrequencies(path string, feed *gtfsparser.Feed) (err
 		}
 	}
 
-	if writer.Sorted {
-		csvwriter.SortByCols(5)
+	writer.sortLines(&csvwriter, "frequencies.txt", 0)
+	if fe := csvwriter.Flush(); fe != nil {
+		return writeError{"frequencies.txt", fe.Error()}
 	}
-	csvwriter.Flush()
 
 	return e
 }
 
 func (writer *Writer) writeTransfers(path string, feed *gtfsparser.Feed) (err error) {
-	if len(feed.Transfers) == 0 {
+	if len(feed.Transfers) == 0 && writer.PartialFeed != PartialStubs {
 		return writer.delExistingFile(path, "transfers.txt")
 	}
 	file, e := writer.getFileForWriting(path, "transfers.txt")
@@ -1188,11 +1881,11 @@ func (writer *Writer) writeTransfers(path string, feed *gtfsparser.Feed) (err er
 		return errors.New("Could not open required file transfers.txt for writing")
 	}
 
-	csvwriter := NewCsvWriter(file)
+	csvwriter := writer.newCsvWriter(file, "transfers.txt")
 
 	defer func() {
 		if r := recover(); r != nil {
-			err = writeError{"transfers.txt", r.(error).Error()}
+			err = writer.recovered("transfers.txt", r)
 		}
 	}()
 
@@ -1213,39 +1906,30 @@ func (writer *Writer) writeTransfers(path string, feed *gtfsparser.Feed) (err er
 		csvwriter.SetOrder(feed.ColOrders.Transfers)
 	}
 
+	// transfer keys with merged stops resolved
+	keys := make(map[gtfs.TransferKey]bool, len(feed.Transfers))
+	for tk := range feed.Transfers {
+		keys[writer.transferKey(tk)] = true
+	}
+
+	written := make(map[gtfs.TransferKey]bool, len(feed.Transfers))
+
 	for tk, tv := range feed.Transfers {
-		transferType := tv.Transfer_type
-		if transferType == 0 {
-			transferType = -1
+		if writer.excl.transfer(tk) {
+			writer.change("transfers.txt", transferId(tk), "", ChangeDropped, "", "")
+			continue
 		}
 
-		from_sid := ""
-		to_sid := ""
-		from_rid := ""
-		to_rid := ""
-		from_tid := ""
-		to_tid := ""
+		mk := writer.transferKey(tk)
 
-		if tk.From_stop != nil {
-			from_sid = tk.From_stop.Id
-		}
-		if tk.To_stop != nil {
-			to_sid = tk.To_stop.Id
-		}
-		if tk.From_route != nil {
-			from_rid = tk.From_route.Id
-		}
-		if tk.To_route != nil {
-			to_rid = tk.To_route.Id
-		}
-		if tk.From_trip != nil {
-			from_tid = tk.From_trip.Id
-		}
-		if tk.To_trip != nil {
-			to_tid = tk.To_trip.Id
+		if written[mk] {
+			// duplicate because of merged stops
+			writer.change("transfers.txt", transferId(tk), "", ChangeMerged, transferId(tk), transferId(mk))
+			continue
 		}
+		written[mk] = true
 
-		row := []string{from_sid, to_sid, from_rid, to_rid, from_tid, to_tid, posIntToString(transferType), posIntToString(tv.Min_transfer_time)}
+		row := writer.transferRow(mk, tv)
 
 		for _, name := range addFieldsOrder {
 			if vald, ok := feed.TransfersAddFlds[name][tk]; ok {
@@ -1256,18 +1940,33 @@ func (writer *Writer) writeTransfers(path string, feed *gtfsparser.Feed) (err er
 		}
 
 		csvwriter.WriteCsvLine(row)
+
+		if !writer.SymmetricTransfers || tv.Transfer_type == 4 || tv.Transfer_type == 5 {
+			// in-seat transfers cannot be reversed
+			continue
+		}
+
+		rev := gtfs.TransferKey{From_stop: mk.To_stop, To_stop: mk.From_stop, From_route: mk.To_route, To_route: mk.From_route, From_trip: mk.To_trip, To_trip: mk.From_trip}
+
+		if !keys[rev] && !written[rev] {
+			written[rev] = true
+			// reversed transfer inherits the additional fields
+			revRow := append(writer.transferRow(rev, tv), row[8:]...)
+			csvwriter.WriteCsvLine(revRow)
+			writer.change("transfers.txt", transferId(rev), "", ChangeCreated, "", "")
+		}
 	}
 
-	if writer.Sorted {
-		csvwriter.SortByCols(4)
+	writer.sortLines(&csvwriter, "transfers.txt", 4)
+	if fe := csvwriter.Flush(); fe != nil {
+		return writeError{"transfers.txt", fe.Error()}
 	}
-	csvwriter.Flush()
 
 	return e
 }
 
 func (writer *Writer) writeLevels(path string, feed *gtfsparser.Feed) (err error) {
-	if len(feed.Levels) == 0 {
+	if len(feed.Levels) == 0 && len(writer.genLevels) == 0 && writer.PartialFeed != PartialStubs {
 		return writer.delExistingFile(path, "levels.txt")
 	}
 	file, e := writer.getFileForWriting(path, "levels.txt")
@@ -1276,11 +1975,11 @@ func (writer *Writer) writeLevels(path string, feed *gtfsparser.Feed) (err error
 		return errors.New("Could not open required file levels.txt for writing")
 	}
 
-	csvwriter := NewCsvWriter(file)
+	csvwriter := writer.newCsvWriter(file, "levels.txt")
 
 	defer func() {
 		if r := recover(); r != nil {
-			err = writeError{"levels.txt", r.(error).Error()}
+			err = writer.recovered("levels.txt", r)
 		}
 	}()
 
@@ -1301,6 +2000,10 @@ func (writer *Writer) writeLevels(path string, feed *gtfsparser.Feed) (err error
 	}
 
 	for _, v := range feed.Levels {
+		if writer.excl.levels[v] {
+			continue
+		}
+
 		row := []string{v.Id, writer.formatFloat(v.Index), v.Name}
 		for _, name := range addFieldsOrder {
 			if vald, ok := feed.LevelsAddFlds[name][v.Id]; ok {
@@ -1312,16 +2015,24 @@ func (writer *Writer) writeLevels(path string, feed *gtfsparser.Feed) (err error
 		csvwriter.WriteCsvLine(row)
 	}
 
-	if writer.Sorted {
-		csvwriter.SortByCols(1)
+	for _, v := range writer.genLevels {
+		row := []string{v.Id, writer.formatFloat(v.Index), v.Name}
+		for range addFieldsOrder {
+			row = append(row, "")
+		}
+		csvwriter.WriteCsvLine(row)
+	}
+
+	writer.sortLines(&csvwriter, "levels.txt", 1)
+	if fe := csvwriter.Flush(); fe != nil {
+		return writeError{"levels.txt", fe.Error()}
 	}
-	csvwriter.Flush()
 
 	return e
 }
 
 func (writer *Writer) writePathways(path string, feed *gtfsparser.Feed) (err error) {
-	if len(feed.Pathways) == 0 {
+	if len(feed.Pathways) == 0 && writer.PartialFeed != PartialStubs {
 		return writer.delExistingFile(path, "pathways.txt")
 	}
 	file, e := writer.getFileForWriting(path, "pathways.txt")
@@ -1330,11 +2041,11 @@ func (writer *Writer) writePathways(path string, feed *gtfsparser.Feed) (err err
 		return errors.New("Could not open required file pathways.txt for writing")
 	}
 
-	csvwriter := NewCsvWriter(file)
+	csvwriter := writer.newCsvWriter(file, "pathways.txt")
 
 	defer func() {
 		if r := recover(); r != nil {
-			err = writeError{"pathways.txt", r.(error).Error()}
+			err = writer.recovered("pathways.txt", r)
 		}
 	}()
 
@@ -1355,21 +2066,58 @@ func (writer *Writer) writePathways(path string, feed *gtfsparser.Feed) (err err
 		csvwriter.SetOrder(feed.ColOrders.Pathways)
 	}
 
-	for _, v := range feed.Pathways {
-		length := ""
-		if !math.IsNaN(float64(v.Length)) {
-			length = writer.formatFloat(v.Length)
+	// existing connections, to avoid generating duplicate reversed pathways
+	conns := make(map[pathwayConn]bool)
+	if writer.ReversePathways {
+		for _, v := range feed.Pathways {
+			v = writer.pathway(v)
+			conns[pathwayConn{v.From_stop, v.To_stop, v.Mode}] = true
 		}
-		mwidth := ""
-		if !math.IsNaN(float64(v.Min_width)) {
-			mwidth = writer.formatFloat(v.Min_width)
+	}
+
+	usedIds := make(map[string]bool)
+
+	for _, v := range feed.Pathways {
+		if writer.excl.pathway(v) {
+			writer.change("pathways.txt", v.Id, "", ChangeDropped, "", "")
+			continue
 		}
-		maxslope := ""
-		if v.Max_slope != 0 {
-			maxslope = writer.formatFloat(v.Max_slope)
+
+		merged := writer.pathway(v)
+
+		if merged.From_stop == merged.To_stop && v.From_stop != v.To_stop {
+			// stops have been merged
+			writer.change("pathways.txt", v.Id, "", ChangeDropped, "", "")
+			continue
 		}
 
-		row := []string{v.Id, v.From_stop.Id, v.To_stop.Id, posIntToString(int(v.Mode)), boolToGtfsBool(v.Is_bidirectional, true), length, posIntToString(v.Traversal_time), posNegIntToString(v.Stair_count), maxslope, mwidth, v.Signposted_as, v.Reversed_signposted_as}
+		v = merged
+
+		row := writer.pathwayRow(v)
+		var revRow []string
+
+		if writer.ReversePathways && v.Is_bidirectional {
+			// write both directions explicitly
+			fwd := *v
+			fwd.Is_bidirectional = false
+			row = writer.pathwayRow(&fwd)
+			writer.change("pathways.txt", v.Id, "is_bidirectional", ChangeModified, "1", "0")
+
+			if !conns[pathwayConn{v.To_stop, v.From_stop, v.Mode}] {
+				rev := fwd
+				rev.Id = v.Id + "_rev"
+				for i := 2; feed.Pathways[rev.Id] != nil || usedIds[rev.Id]; i++ {
+					rev.Id = v.Id + "_rev" + strconv.Itoa(i)
+				}
+				usedIds[rev.Id] = true
+				rev.From_stop, rev.To_stop = v.To_stop, v.From_stop
+				rev.Signposted_as, rev.Reversed_signposted_as = v.Reversed_signposted_as, v.Signposted_as
+				rev.Stair_count = -v.Stair_count
+				rev.Max_slope = -v.Max_slope
+				revRow = writer.pathwayRow(&rev)
+				writer.change("pathways.txt", rev.Id, "", ChangeCreated, "", "")
+			}
+		}
 
 		for _, name := range addFieldsOrder {
 			if vald, ok := feed.PathwaysAddFlds[name][v.Id]; ok {
@@ -1380,18 +2128,45 @@ func (writer *Writer) writePathways(path string, feed *gtfsparser.Feed) (err err
 		}
 
 		csvwriter.WriteCsvLine(row)
+
+		if revRow != nil {
+			// reversed pathway inherits the additional fields
+			csvwriter.WriteCsvLine(append(revRow, row[12:]...))
+		}
 	}
 
-	if writer.Sorted {
-		csvwriter.SortByCols(1)
+	writer.sortLines(&csvwriter, "pathways.txt", 1)
+	if fe := csvwriter.Flush(); fe != nil {
+		return writeError{"pathways.txt", fe.Error()}
 	}
-	csvwriter.Flush()
 
 	return e
 }
 
-func (writer *Writer) writeAttributions(path string, feed *gtfsparser.Feed, attrs []EntAttr) (err error) {
-	if len(feed.Attributions) == 0 && len(attrs) == 0 {
+type pathwayConn struct {
+	from, to *gtfs.Stop
+	mode     uint8
+}
+
+func (writer *Writer) pathwayRow(v *gtfs.Pathway) []string {
+	length := ""
+	if !math.IsNaN(float64(v.Length)) {
+		length = writer.formatFloat(v.Length)
+	}
+	mwidth := ""
+	if !math.IsNaN(float64(v.Min_width)) {
+		mwidth = writer.formatFloat(v.Min_width)
+	}
+	maxslope := ""
+	if v.Max_slope != 0 {
+		maxslope = writer.formatFloat(v.Max_slope)
+	}
+
+	return []string{v.Id, v.From_stop.Id, v.To_stop.Id, posIntToString(int(v.Mode)), boolToGtfsBool(v.Is_bidirectional, true), length, posIntToString(v.Traversal_time), posNegIntToString(v.Stair_count), maxslope, mwidth, v.Signposted_as, v.Reversed_signposted_as}
+}
+
+func (writer *Writer) writeAttributions(path string, feed *gtfsparser.Feed, attrs entAttrs) (err error) {
+	if len(attrs) == 0 && writer.PartialFeed != PartialStubs {
 		return writer.delExistingFile(path, "attributions.txt")
 	}
 
@@ -1401,11 +2176,11 @@ func (writer *Writer) writeAttributions(path string, feed *gtfsparser.Feed, attr
 		return errors.New("Could not open required file attributions.txt for writing")
 	}
 
-	csvwriter := NewCsvWriter(file)
+	csvwriter := writer.newCsvWriter(file, "attributions.txt")
 
 	defer func() {
 		if r := recover(); r != nil {
-			err = writeError{"attributions.txt", r.(error).Error()}
+			err = writer.recovered("attributions.txt", r)
 		}
 	}()
 
@@ -1425,18 +2200,13 @@ func (writer *Writer) writeAttributions(path string, feed *gtfsparser.Feed, attr
 		csvwriter.SetOrder(feed.ColOrders.Attributions)
 	}
 
-	for _, a := range feed.Attributions {
-		url := ""
-		if a.Url != nil {
-			url = a.Url.String()
-		}
-
-		email := ""
-		if a.Email != nil {
-			email = a.Email.Address
+	for _, entattr := range attrs {
+		if writer.dupAttrs[entattr] {
+			continue
 		}
 
-		row := []string{a.Id, "", "", "", a.Organization_name, boolToGtfsBool(a.Is_producer, false), boolToGtfsBool(a.Is_operator, false), boolToGtfsBool(a.Is_authority, false), url, email, a.Phone}
+		a := entattr.attr
+		row := writer.attributionRow(entattr)
 
 		// additional fields
 		for _, name := range addFieldsOrder {
@@ -1450,53 +2220,104 @@ func (writer *Writer) writeAttributions(path string, feed *gtfsparser.Feed, attr
 		csvwriter.WriteCsvLine(row)
 	}
 
-	for _, entattr := range attrs {
-		url := ""
-		a := entattr.attr
-		if a.Url != nil {
-			url = a.Url.String()
-		}
+	writer.sortLines(&csvwriter, "attributions.txt", 1)
 
-		email := ""
-		if a.Email != nil {
-			email = a.Email.Address
-		}
+	if fe := csvwriter.Flush(); fe != nil {
+		return writeError{"attributions.txt", fe.Error()}
+	}
 
-		routeid := ""
-		agencyid := ""
-		tripid := ""
+	return e
+}
 
-		if entattr.trip != nil {
-			tripid = entattr.trip.Id
-		}
-		if entattr.route != nil {
-			routeid = entattr.route.Id
-		}
-		if entattr.agency != nil {
-			agencyid = entattr.agency.Id
+// collectAttributions returns all attributions written, sorted
+func (writer *Writer) collectAttributions(feed *gtfsparser.Feed) entAttrs {
+	// feed-level attributions are not bound to any entity
+	all := make(entAttrs, 0, len(feed.Attributions))
+	for _, a := range feed.Attributions {
+		all = append(all, EntAttr{a, nil, nil, nil})
+	}
+
+	for _, v := range feed.Agencies {
+		for _, attr := range v.Attributions {
+			all = append(all, EntAttr{attr, nil, v, nil})
 		}
+	}
 
-		row := []string{a.Id, agencyid, routeid, tripid, a.Organization_name, boolToGtfsBool(a.Is_producer, false), boolToGtfsBool(a.Is_operator, false), boolToGtfsBool(a.Is_authority, false), url, email, a.Phone}
+	for _, r := range feed.Routes {
+		for _, attr := range r.Attributions {
+			all = append(all, EntAttr{attr, writer.route(r), nil, nil})
+		}
+	}
 
-		// additional fields
-		for _, name := range addFieldsOrder {
-			if vald, ok := feed.AttributionsAddFlds[name][a]; ok {
-				row = append(row, vald)
-			} else {
-				row = append(row, "")
+	for _, t := range feed.Trips {
+		if t.Attributions != nil {
+			for _, attr := range *t.Attributions {
+				all = append(all, EntAttr{attr, nil, nil, t})
 			}
 		}
+	}
 
-		csvwriter.WriteCsvLine(row)
+	// the collected attributions come in map order, always sort them
+	sort.Sort(all)
+
+	return all
+}
+
+func (writer *Writer) attributionRow(entattr EntAttr) []string {
+	url := ""
+	a := entattr.attr
+	if a.Url != nil {
+		url = a.Url.String()
 	}
 
-	if writer.Sorted {
-		csvwriter.SortByCols(1)
+	email := ""
+	if a.Email != nil {
+		email = a.Email.Address
 	}
 
-	csvwriter.Flush()
+	agencyid, routeid, tripid := entattr.ids()
+	if entattr.trip != nil {
+		tripid = writer.tripId(entattr.trip)
+	}
 
-	return e
+	id := a.Id
+	if rid, ok := writer.attrIds[entattr]; ok {
+		id = rid
+	}
+
+	return []string{id, agencyid, routeid, tripid, a.Organization_name, writer.optionalBool("is_producer", a.Is_producer), writer.optionalBool("is_operator", a.Is_operator), writer.optionalBool("is_authority", a.Is_authority), url, email, a.Phone}
+}
+
+func (writer *Writer) transferRow(tk gtfs.TransferKey, tv gtfs.TransferVal) []string {
+	transferType := writer.omitDefault(int(tv.Transfer_type), 0)
+
+	from_sid := ""
+	to_sid := ""
+	from_rid := ""
+	to_rid := ""
+	from_tid := ""
+	to_tid := ""
+
+	if tk.From_stop != nil {
+		from_sid = tk.From_stop.Id
+	}
+	if tk.To_stop != nil {
+		to_sid = tk.To_stop.Id
+	}
+	if tk.From_route != nil {
+		from_rid = tk.From_route.Id
+	}
+	if tk.To_route != nil {
+		to_rid = tk.To_route.Id
+	}
+	if tk.From_trip != nil {
+		from_tid = writer.tripId(tk.From_trip)
+	}
+	if tk.To_trip != nil {
+		to_tid = writer.tripId(tk.To_trip)
+	}
+
+	return []string{from_sid, to_sid, from_rid, to_rid, from_tid, to_tid, posIntToString(transferType), posIntToString(tv.Min_transfer_time)}
 }
 
 func dateToString(date gtfs.Date) string {
@@ -1511,6 +2332,22 @@ func timeToString(time gtfs.Time) string {
 	return fmt.Sprintf("%02d:%02d:%02d", time.Hour, time.Minute, time.Second)
 }
 
+// stopTimeSeconds writes the arrival and departure time of a stop time
+// in seconds since midnight to row, empty if it has no times
+func stopTimeSeconds(st *gtfs.StopTime, row []string) {
+	if st.Arrival_time().Empty() || st.Departure_time().Empty() {
+		row[0] = ""
+		row[1] = ""
+		return
+	}
+	row[0] = posIntToString(timeToSeconds(st.Arrival_time()))
+	row[1] = posIntToString(timeToSeconds(st.Departure_time()))
+}
+
+func timeToSeconds(time gtfs.Time) int {
+	return int(time.Hour)*3600 + int(time.Minute)*60 + int(time.Second)
+}
+
 func posIntToString(i int) string {
 	if i < 0 {
 		// encoding of "empty"
@@ -1519,6 +2356,45 @@ func posIntToString(i int) string {
 	return strconv.FormatInt(int64(i), 10)
 }
 
+// oneLine replaces the newlines in a text value with spaces, unless
+// KeepNewlines is set, in which case the value is quoted by the CSV writer
+func (o RowOptions) oneLine(s string) string {
+	if o.KeepNewlines {
+		return s
+	}
+	return strings.Replace(s, "\n", " ", -1)
+}
+
+// oneLine replaces the newlines in a text value with the settings of
+// the writer
+func (writer *Writer) oneLine(s string) string {
+	return writer.rowOptions().oneLine(s)
+}
+
+// omitDefault returns -1, the encoding of "empty", for the default
+// value def of an optional column, unless ExplicitDefaults is set
+func (o RowOptions) omitDefault(i int, def int) int {
+	if i == def && !o.ExplicitDefaults {
+		return -1
+	}
+	return i
+}
+
+// omitDefault omits the default value of an optional column with the
+// settings of the writer
+func (writer *Writer) omitDefault(i int, def int) int {
+	return writer.rowOptions().omitDefault(i, def)
+}
+
+// defaultValue returns the default value v of an optional column if
+// ExplicitDefaults is set, and an empty string otherwise
+func (writer *Writer) defaultValue(v string) string {
+	if writer.ExplicitDefaults {
+		return v
+	}
+	return ""
+}
+
 func posNegIntToString(i int) string {
 	if i == 0 {
 		// encoding of "empty"
@@ -1527,6 +2403,15 @@ func posNegIntToString(i int) string {
 	return strconv.FormatInt(int64(i), 10)
 }
 
+// optionalBool renders the value of an optional boolean column, false
+// is written as configured in FalseValues
+func (writer *Writer) optionalBool(column string, v bool) string {
+	if fv, ok := writer.FalseValues[column]; ok {
+		return boolToGtfsBool(v, fv == FalseExplicit)
+	}
+	return boolToGtfsBool(v, writer.ExplicitDefaults)
+}
+
 func boolToGtfsBool(v bool, full bool) string {
 	if v {
 		return "1"
@@ -0,0 +1,112 @@
+// Copyright 2016 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfswriter
+
+import (
+	"bytes"
+	"github.com/patrickbr/gtfsparser"
+	"sort"
+	"strconv"
+)
+
+// prepareZoneIds maps all zone IDs used by stops and fare rules to
+// short sequential tokens if CompactZoneIds is set
+func (writer *Writer) prepareZoneIds(feed *gtfsparser.Feed) {
+	if !writer.CompactZoneIds {
+		return
+	}
+
+	zones := make(map[string]bool)
+
+	for _, s := range feed.Stops {
+		if !writer.excl.stops[s] {
+			zones[s.Zone_id] = true
+		}
+	}
+
+	for _, fa := range feed.FareAttributes {
+		for _, r := range fa.Rules {
+			zones[r.Origin_id] = true
+			zones[r.Destination_id] = true
+			zones[r.Contains_id] = true
+		}
+	}
+
+	delete(zones, "")
+
+	ids := make([]string, 0, len(zones))
+	for id := range zones {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for i, id := range ids {
+		writer.Report.ZoneIds[id] = strconv.Itoa(i + 1)
+	}
+
+	// changes are recorded for each stop and fare rule referencing an
+	// altered zone
+	for _, s := range feed.Stops {
+		if writer.excl.stops[s] || writer.merged(s) {
+			continue
+		}
+		if z := writer.zoneId(s.Zone_id); z != s.Zone_id {
+			writer.change("stops.txt", s.Id, "zone_id", ChangeModified, s.Zone_id, z)
+		}
+	}
+
+	fields := []string{"origin_id", "destination_id", "contains_id"}
+
+	for _, fa := range feed.FareAttributes {
+		for _, r := range fa.Rules {
+			for i, id := range []string{r.Origin_id, r.Destination_id, r.Contains_id} {
+				if z := writer.zoneId(id); z != id {
+					writer.change("fare_rules.txt", fa.Id, fields[i], ChangeModified, id, z)
+				}
+			}
+		}
+	}
+}
+
+// zoneId returns the zone ID written for an original zone ID
+func (writer *Writer) zoneId(id string) string {
+	if !writer.CompactZoneIds || len(id) == 0 {
+		return id
+	}
+	return writer.Report.ZoneIds[id]
+}
+
+// writeZoneIdMapping writes the mapping of original to written zone
+// IDs to ZoneIdMappingFile, as CSV ordered by the original IDs
+func (writer *Writer) writeZoneIdMapping() error {
+	if len(writer.ZoneIdMappingFile) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(writer.Report.ZoneIds))
+	for id := range writer.Report.ZoneIds {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var buf bytes.Buffer
+	csvwriter := NewCsvWriter(&buf)
+	csvwriter.SetQuote(writer.Quoting)
+	header := []string{"original_zone_id", "zone_id"}
+	csvwriter.SetHeader(header, header)
+	csvwriter.WriteHeader()
+
+	for _, id := range ids {
+		csvwriter.WriteCsvLineRaw([]string{id, writer.Report.ZoneIds[id]})
+	}
+
+	if e := csvwriter.FlushFile(); e != nil {
+		return writeError{writer.ZoneIdMappingFile, e.Error()}
+	}
+
+	return writer.writeSidecar(writer.ZoneIdMappingFile, buf.Bytes())
+}